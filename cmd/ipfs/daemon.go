@@ -29,6 +29,7 @@ import (
 
 	"github.com/hashicorp/go-multierror"
 	cmds "github.com/ipfs/go-ipfs-cmds"
+	logging "github.com/ipfs/go-log"
 	mprome "github.com/ipfs/go-metrics-prometheus"
 	goprocess "github.com/jbenet/goprocess"
 	ma "github.com/multiformats/go-multiaddr"
@@ -43,11 +44,13 @@ const (
 	initOptionKwd             = "init"
 	initConfigOptionKwd       = "init-config"
 	initProfileOptionKwd      = "init-profile"
+	logFormatKwd              = "log-format"
 	ipfsMountKwd              = "mount-ipfs"
 	ipnsMountKwd              = "mount-ipns"
 	migrateKwd                = "migrate"
 	mountKwd                  = "mount"
 	offlineKwd                = "offline" // global option
+	readOnlyApiKwd            = "read-only-api"
 	routingOptionKwd          = "routing"
 	routingOptionSupernodeKwd = "supernode"
 	routingOptionDHTClientKwd = "dhtclient"
@@ -64,6 +67,10 @@ const (
 	// swarmAddrKwd  = "address-swarm"
 )
 
+// metricsPath is where Prometheus metrics are served, on the API or on the
+// listeners in Addresses.Metrics.
+const metricsPath = "/debug/metrics/prometheus"
+
 var daemonCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Run a network-connected IPFS node.",
@@ -133,6 +140,19 @@ environment variable:
 
   export IPFS_PATH=/path/to/ipfsrepo
 
+Read-only API
+
+A daemon whose API is exposed publicly, for example next to a gateway, can
+refuse every command that changes its state (add, pin, key, name publish, ...)
+by running it as:
+
+  ipfs daemon --read-only-api
+
+Only the read-only commands that the gateway also serves under /api/v0 (cat,
+get, ls, resolve, ...) are then available on the API, and the API never serves
+a writable gateway. This also applies to the local 'ipfs' command, which goes
+through the API while the daemon runs.
+
 Routing
 
 IPFS by default will use a DHT for content routing. There is a highly
@@ -166,6 +186,7 @@ Headers.
 		cmds.StringOption(ipfsMountKwd, "Path to the mountpoint for IPFS (if using --mount). Defaults to config setting."),
 		cmds.StringOption(ipnsMountKwd, "Path to the mountpoint for IPNS (if using --mount). Defaults to config setting."),
 		cmds.BoolOption(unrestrictedApiAccessKwd, "Allow API access to unlisted hashes"),
+		cmds.BoolOption(readOnlyApiKwd, "Only serve the read-only commands on the API, like the gateway does"),
 		cmds.BoolOption(unencryptTransportKwd, "Disable transport encryption (for debugging protocols)"),
 		cmds.BoolOption(enableGCKwd, "Enable automatic periodic repo garbage collection"),
 		cmds.BoolOption(adjustFDLimitKwd, "Check and raise file descriptor limits if needed").WithDefault(true),
@@ -173,6 +194,7 @@ Headers.
 		cmds.BoolOption(enablePubSubKwd, "Instantiate the ipfs daemon with the experimental pubsub feature enabled."),
 		cmds.BoolOption(enableIPNSPubSubKwd, "Enable IPNS record distribution through pubsub; enables pubsub."),
 		cmds.BoolOption(enableMultiplexKwd, "Add the experimental 'go-multiplex' stream muxer to libp2p on construction.").WithDefault(true),
+		cmds.StringOption(logFormatKwd, "Log output format: color, nocolor or json. Overrides Logging.Format."),
 
 		// TODO: add way to override addresses. tricky part: updating the config if also --init.
 		// cmds.StringOption(apiAddrKwd, "Address for the daemon rpc API (overrides config)"),
@@ -194,6 +216,14 @@ func defaultMux(path string) corehttp.ServeOption {
 }
 
 func daemonFunc(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) (_err error) {
+	// Switch the log format before anything is logged.
+	logFormat, _ := req.Options[logFormatKwd].(string)
+	if logFormat != "" {
+		if err := logging.SetFormat(logFormat); err != nil {
+			return err
+		}
+	}
+
 	// Inject metrics before we do anything
 	err := mprome.Inject()
 	if err != nil {
@@ -291,6 +321,18 @@ func daemonFunc(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment
 	// fail before we get to that. It can't hurt to close it twice.
 	defer repo.Close()
 
+	if logFormat == "" {
+		cfg, err := repo.Config()
+		if err != nil {
+			return err
+		}
+		if cfg.Logging.Format != "" {
+			if err := logging.SetFormat(cfg.Logging.Format); err != nil {
+				return fmt.Errorf("Logging.Format: %s", err)
+			}
+		}
+	}
+
 	offline, _ := req.Options[offlineKwd].(bool)
 	ipnsps, _ := req.Options[enableIPNSPubSubKwd].(bool)
 	pubsub, _ := req.Options[enablePubSubKwd].(bool)
@@ -402,6 +444,12 @@ func daemonFunc(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment
 		return err
 	}
 
+	// construct metrics endpoint - if Addresses.Metrics is set
+	metricsErrc, err := serveHTTPMetrics(req, cctx)
+	if err != nil {
+		return err
+	}
+
 	// Add ipfs version info to prometheus metrics
 	var ipfsInfoMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "ipfs_info",
@@ -432,7 +480,7 @@ func daemonFunc(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment
 	// collect long-running errors and block for shutdown
 	// TODO(cryptix): our fuse currently doesnt follow this pattern for graceful shutdown
 	var errs error
-	for err := range merge(apiErrc, gwErrc, gcErrc) {
+	for err := range merge(apiErrc, gwErrc, metricsErrc, gcErrc) {
 		if err != nil {
 			errs = multierror.Append(errs, err)
 		}
@@ -499,25 +547,35 @@ func serveHTTPApi(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, error
 	// only the webui objects are allowed.
 	// if you know what you're doing, go ahead and pass --unrestricted-api.
 	unrestricted, _ := req.Options[unrestrictedApiAccessKwd].(bool)
+	readOnly, _ := req.Options[readOnlyApiKwd].(bool)
 	gatewayOpt := corehttp.GatewayOption(false, corehttp.WebUIPaths...)
 	if unrestricted {
-		gatewayOpt = corehttp.GatewayOption(true, "/ipfs", "/ipns")
+		gatewayOpt = corehttp.GatewayOption(!readOnly, "/ipfs", "/ipns")
+	}
+
+	commandsOpt := corehttp.CommandsOption(*cctx)
+	if readOnly {
+		commandsOpt = corehttp.CommandsROOption(*cctx)
 	}
 
 	var opts = []corehttp.ServeOption{
 		corehttp.MetricsCollectionOption("api"),
 		corehttp.CheckVersionOption(),
-		corehttp.CommandsOption(*cctx),
+		commandsOpt,
 		corehttp.WebUIOption,
 		gatewayOpt,
 		corehttp.VersionOption(),
 		defaultMux("/debug/vars"),
 		defaultMux("/debug/pprof/"),
 		corehttp.MutexFractionOption("/debug/pprof-mutex/"),
-		corehttp.MetricsScrapingOption("/debug/metrics/prometheus"),
 		corehttp.LogOption(),
 	}
 
+	// metrics are only served here if there's no dedicated listener for them
+	if len(cfg.Addresses.Metrics) == 0 {
+		opts = append(opts, corehttp.MetricsScrapingOption(metricsPath))
+	}
+
 	if len(cfg.Gateway.RootRedirect) > 0 {
 		opts = append(opts, corehttp.RedirectOption("", cfg.Gateway.RootRedirect))
 	}
@@ -634,14 +692,18 @@ func serveHTTPGateway(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, e
 	cmdctx := *cctx
 	cmdctx.Gateway = true
 
-	var opts = []corehttp.ServeOption{
+	var opts []corehttp.ServeOption
+	if len(cfg.Gateway.TrustedProxies) > 0 {
+		opts = append(opts, corehttp.ForwardedForOption(cfg.Gateway.TrustedProxies))
+	}
+	opts = append(opts,
 		corehttp.MetricsCollectionOption("gateway"),
 		corehttp.IPNSHostnameOption(),
 		corehttp.GatewayOption(writable, "/ipfs", "/ipns"),
 		corehttp.VersionOption(),
 		corehttp.CheckVersionOption(),
 		corehttp.CommandsROOption(cmdctx),
-	}
+	)
 
 	if cfg.Experimental.P2pHttpProxy {
 		opts = append(opts, corehttp.ProxyOption())
@@ -674,6 +736,79 @@ func serveHTTPGateway(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, e
 	return errc, nil
 }
 
+// serveHTTPMetrics serves Prometheus metrics on the listeners configured in
+// Addresses.Metrics, if any, optionally requiring a bearer token.
+func serveHTTPMetrics(req *cmds.Request, cctx *oldcmds.Context) (<-chan error, error) {
+	cfg, err := cctx.GetConfig()
+	if err != nil {
+		return nil, fmt.Errorf("serveHTTPMetrics: GetConfig() failed: %s", err)
+	}
+
+	listeners, err := sockets.TakeListeners("io.ipfs.metrics")
+	if err != nil {
+		return nil, fmt.Errorf("serveHTTPMetrics: socket activation failed: %s", err)
+	}
+
+	listenerAddrs := make(map[string]bool, len(listeners))
+	for _, listener := range listeners {
+		listenerAddrs[string(listener.Multiaddr().Bytes())] = true
+	}
+
+	for _, addr := range cfg.Addresses.Metrics {
+		metricsMaddr, err := ma.NewMultiaddr(addr)
+		if err != nil {
+			return nil, fmt.Errorf("serveHTTPMetrics: invalid metrics address: %q (err: %s)", addr, err)
+		}
+
+		if listenerAddrs[string(metricsMaddr.Bytes())] {
+			continue
+		}
+
+		metricsLis, err := manet.Listen(metricsMaddr)
+		if err != nil {
+			return nil, fmt.Errorf("serveHTTPMetrics: manet.Listen(%s) failed: %s", metricsMaddr, err)
+		}
+		listenerAddrs[string(metricsMaddr.Bytes())] = true
+		listeners = append(listeners, metricsLis)
+	}
+
+	if len(listeners) == 0 {
+		return nil, nil
+	}
+
+	for _, listener := range listeners {
+		fmt.Printf("Metrics server listening on %s\n", listener.Multiaddr())
+	}
+
+	var opts []corehttp.ServeOption
+	if cfg.Metrics.BearerToken != "" {
+		opts = append(opts, corehttp.BearerTokenOption(cfg.Metrics.BearerToken))
+	}
+	opts = append(opts, corehttp.MetricsScrapingOption(metricsPath))
+
+	node, err := cctx.ConstructNode()
+	if err != nil {
+		return nil, fmt.Errorf("serveHTTPMetrics: ConstructNode() failed: %s", err)
+	}
+
+	errc := make(chan error)
+	var wg sync.WaitGroup
+	for _, lis := range listeners {
+		wg.Add(1)
+		go func(lis manet.Listener) {
+			defer wg.Done()
+			errc <- corehttp.Serve(node, manet.NetListener(lis), opts...)
+		}(lis)
+	}
+
+	go func() {
+		wg.Wait()
+		close(errc)
+	}()
+
+	return errc, nil
+}
+
 //collects options and opens the fuse mountpoint
 func mountFuse(req *cmds.Request, cctx *oldcmds.Context) error {
 	cfg, err := cctx.GetConfig()
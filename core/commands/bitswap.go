@@ -1,8 +1,14 @@
 package commands
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
 	e "github.com/ipfs/go-ipfs/core/commands/e"
@@ -10,8 +16,10 @@ import (
 	humanize "github.com/dustin/go-humanize"
 	bitswap "github.com/ipfs/go-bitswap"
 	decision "github.com/ipfs/go-bitswap/decision"
+	cid "github.com/ipfs/go-cid"
 	cidutil "github.com/ipfs/go-cidutil"
 	cmds "github.com/ipfs/go-ipfs-cmds"
+	coreiface "github.com/ipfs/interface-go-ipfs-core"
 	peer "github.com/libp2p/go-libp2p-core/peer"
 )
 
@@ -30,19 +38,46 @@ var BitswapCmd = &cmds.Command{
 }
 
 const (
-	peerOptionName = "peer"
+	peerOptionName             = "peer"
+	wantlistSessionOptionName  = "session"
+	wantlistSessionsOptionName = "sessions"
 )
 
+// WantlistSession is a bitswap session with wants on the local wantlist.
+type WantlistSession struct {
+	ID    uint64
+	Wants int
+}
+
+// Wantlist is the output of 'ipfs bitswap wantlist'. Entries is only set when
+// --verbose or --session is passed, and Sessions only when --sessions is.
+type Wantlist struct {
+	Keys     []cid.Cid
+	Entries  []coreiface.WantlistEntry `json:",omitempty"`
+	Sessions []WantlistSession         `json:",omitempty"`
+}
+
 var showWantlistCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Show blocks currently on the wantlist.",
 		ShortDescription: `
-Print out all blocks currently on the bitswap wantlist for the local peer.`,
+Print out all blocks currently on the bitswap wantlist for the local peer.
+
+With --verbose, each block is printed along with how long it has been on the
+wantlist and the IDs of the bitswap sessions that want it, oldest first.
+
+With --sessions, the bitswap sessions that have wants are listed instead,
+along with how many wants each has, busiest first. --session lists only the
+wants of the given session, in the same format as --verbose.
+`,
 	},
 	Options: []cmds.Option{
 		cmds.StringOption(peerOptionName, "p", "Specify which peer to show wantlist for. Default: self."),
+		cmds.BoolOption(bitswapVerboseOptionName, "v", "Show when each want was added and which sessions want it. Only applies to the local wantlist."),
+		cmds.Uint64Option(wantlistSessionOptionName, "Only show the wants of the given session. Implies --verbose."),
+		cmds.BoolOption(wantlistSessionsOptionName, "List the sessions that have wants, and how many wants each has."),
 	},
-	Type: KeyList{},
+	Type: Wantlist{},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		nd, err := cmdenv.GetNode(env)
 		if err != nil {
@@ -58,6 +93,10 @@ Print out all blocks currently on the bitswap wantlist for the local peer.`,
 			return e.TypeErr(bs, nd.Exchange)
 		}
 
+		verbose, _ := req.Options[bitswapVerboseOptionName].(bool)
+		ses, sesFound := req.Options[wantlistSessionOptionName].(uint64)
+		listSessions, _ := req.Options[wantlistSessionsOptionName].(bool)
+
 		pstr, found := req.Options[peerOptionName].(string)
 		if found {
 			pid, err := peer.Decode(pstr)
@@ -65,18 +104,90 @@ Print out all blocks currently on the bitswap wantlist for the local peer.`,
 				return err
 			}
 			if pid != nd.Identity {
-				return cmds.EmitOnce(res, &KeyList{bs.WantlistForPeer(pid)})
+				if verbose || sesFound || listSessions {
+					return errors.New("--verbose, --session and --sessions only apply to the local wantlist")
+				}
+				return cmds.EmitOnce(res, &Wantlist{Keys: bs.WantlistForPeer(pid)})
 			}
 		}
 
-		return cmds.EmitOnce(res, &KeyList{bs.GetWantlist()})
+		if !verbose && !sesFound && !listSessions {
+			return cmds.EmitOnce(res, &Wantlist{Keys: bs.GetWantlist()})
+		}
+
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+
+		if listSessions {
+			sessions, err := api.Bitswap().WantlistSessions(req.Context)
+			if err != nil {
+				return err
+			}
+			out := &Wantlist{Sessions: make([]WantlistSession, 0, len(sessions))}
+			for id, wants := range sessions {
+				out.Sessions = append(out.Sessions, WantlistSession{ID: id, Wants: wants})
+			}
+			return cmds.EmitOnce(res, out)
+		}
+
+		var entries []coreiface.WantlistEntry
+		if sesFound {
+			entries, err = api.Bitswap().SessionWantlist(req.Context, ses)
+		} else {
+			entries, err = api.Bitswap().Wantlist(req.Context)
+		}
+		if err != nil {
+			return err
+		}
+
+		out := &Wantlist{
+			Keys:    make([]cid.Cid, 0, len(entries)),
+			Entries: entries,
+		}
+		for _, e := range entries {
+			out.Keys = append(out.Keys, e.Cid)
+		}
+		return cmds.EmitOnce(res, out)
 	},
 	Encoders: cmds.EncoderMap{
-		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *KeyList) error {
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *Wantlist) error {
 			enc, err := cmdenv.GetLowLevelCidEncoder(req)
 			if err != nil {
 				return err
 			}
+
+			if len(out.Sessions) > 0 {
+				sort.Slice(out.Sessions, func(i, j int) bool {
+					if out.Sessions[i].Wants != out.Sessions[j].Wants {
+						return out.Sessions[i].Wants > out.Sessions[j].Wants
+					}
+					return out.Sessions[i].ID < out.Sessions[j].ID
+				})
+				tw := tabwriter.NewWriter(w, 4, 4, 2, ' ', 0)
+				for _, ses := range out.Sessions {
+					fmt.Fprintf(tw, "%d\t%d\n", ses.ID, ses.Wants)
+				}
+				return tw.Flush()
+			}
+
+			if len(out.Entries) > 0 {
+				sort.Slice(out.Entries, func(i, j int) bool {
+					return out.Entries[i].Added.Before(out.Entries[j].Added)
+				})
+				tw := tabwriter.NewWriter(w, 4, 4, 2, ' ', 0)
+				for _, e := range out.Entries {
+					sessions := make([]string, 0, len(e.Sessions))
+					for _, ses := range e.Sessions {
+						sessions = append(sessions, strconv.FormatUint(ses, 10))
+					}
+					age := time.Since(e.Added).Round(time.Second)
+					fmt.Fprintf(tw, "%s\t%s\t%s\n", enc.Encode(e.Cid), age, strings.Join(sessions, ","))
+				}
+				return tw.Flush()
+			}
+
 			// sort the keys first
 			cidutil.Sort(out.Keys)
 			for _, key := range out.Keys {
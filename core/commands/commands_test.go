@@ -127,6 +127,8 @@ func TestCommands(t *testing.T) {
 		"/filestore/ls",
 		"/filestore/verify",
 		"/files/write",
+		"/gateway",
+		"/gateway/samples",
 		"/get",
 		"/id",
 		"/key",
@@ -175,6 +177,10 @@ func TestCommands(t *testing.T) {
 		"/pin/rm",
 		"/pin/update",
 		"/pin/verify",
+		"/provide",
+		"/provide/cancel",
+		"/provide/queue",
+		"/provide/queue/ls",
 		"/pubsub",
 		"/pubsub/ls",
 		"/pubsub/peers",
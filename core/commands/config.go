@@ -10,7 +10,9 @@ import (
 	"os/exec"
 	"strings"
 
+	"github.com/ipfs/go-ipfs/core"
 	"github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	"github.com/ipfs/go-ipfs/core/node"
 	"github.com/ipfs/go-ipfs/repo"
 	"github.com/ipfs/go-ipfs/repo/fsrepo"
 
@@ -34,6 +36,7 @@ const (
 	configBoolOptionName   = "bool"
 	configJSONOptionName   = "json"
 	configDryRunOptionName = "dry-run"
+	configApplyOptionName  = "apply"
 )
 
 var ConfigCmd = &cmds.Command{
@@ -57,6 +60,12 @@ Get the value of the 'Datastore.Path' key:
 Set the value of the 'Datastore.Path' key:
 
   $ ipfs config Datastore.Path ~/.ipfs/datastore
+
+Most settings only take effect when the daemon is restarted. Settings that
+can be changed at runtime (currently those in the DNS section) are applied to
+the running daemon when --apply is passed:
+
+  $ ipfs config --apply DNS.ProofCache.TTL 1m
 `,
 	},
 	Subcommands: map[string]*cmds.Command{
@@ -72,11 +81,28 @@ Set the value of the 'Datastore.Path' key:
 	Options: []cmds.Option{
 		cmds.BoolOption(configBoolOptionName, "Set a boolean value."),
 		cmds.BoolOption(configJSONOptionName, "Parse stringified JSON."),
+		cmds.BoolOption(configApplyOptionName, "Apply runtime-adjustable settings to the running daemon."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		args := req.Arguments
 		key := args[0]
 
+		var nd *core.IpfsNode
+		if apply, _ := req.Options[configApplyOptionName].(bool); apply {
+			if len(args) != 2 {
+				return errors.New("--apply can only be used when setting a value")
+			}
+
+			var err error
+			nd, err = cmdenv.GetNode(env)
+			if err != nil {
+				return err
+			}
+			if !nd.IsDaemon {
+				return errors.New("--apply requires a running daemon")
+			}
+		}
+
 		var output *ConfigField
 
 		// This is a temporary fix until we move the private key out of the config file
@@ -96,18 +122,23 @@ Set the value of the 'Datastore.Path' key:
 		}
 		defer r.Close()
 		if len(args) == 2 {
-			value := args[1]
+			var value interface{} = args[1]
 
 			if parseJSON, _ := req.Options[configJSONOptionName].(bool); parseJSON {
 				var jsonVal interface{}
-				if err := json.Unmarshal([]byte(value), &jsonVal); err != nil {
+				if err := json.Unmarshal([]byte(args[1]), &jsonVal); err != nil {
 					err = fmt.Errorf("failed to unmarshal json. %s", err)
 					return err
 				}
-
-				output, err = setConfig(r, key, jsonVal)
+				value = jsonVal
 			} else if isbool, _ := req.Options[configBoolOptionName].(bool); isbool {
-				output, err = setConfig(r, key, value == "true")
+				value = args[1] == "true"
+			}
+
+			if nd != nil {
+				output, err = setAppliedConfig(r, key, value, func(cfg *config.Config) error {
+					return node.ApplyDNSConfig(nd.Namesys, cfg.DNS)
+				})
 			} else {
 				output, err = setConfig(r, key, value)
 			}
@@ -449,6 +480,38 @@ func setConfig(r repo.Repo, key string, value interface{}) (*ConfigField, error)
 	return getConfig(r, key)
 }
 
+// setAppliedConfig sets a config value like setConfig, then passes the new
+// config to apply, which pushes it to the running node. If apply fails, the
+// previous config is restored, so that a value the node rejects isn't left
+// behind to stop the next daemon from starting.
+func setAppliedConfig(r repo.Repo, key string, value interface{}, apply func(*config.Config) error) (*ConfigField, error) {
+	oldCfg, err := r.Config()
+	if err != nil {
+		return nil, err
+	}
+	oldCfg, err = oldCfg.Clone()
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := setConfig(r, key, value)
+	if err != nil {
+		return nil, err
+	}
+
+	newCfg, err := r.Config()
+	if err != nil {
+		return nil, err
+	}
+	if err := apply(newCfg); err != nil {
+		if rerr := r.SetConfig(oldCfg); rerr != nil {
+			return nil, fmt.Errorf("config value could not be applied: %s; restoring the previous config failed: %s", err, rerr)
+		}
+		return nil, fmt.Errorf("config value could not be applied and was not set: %s", err)
+	}
+	return output, nil
+}
+
 func editConfig(filename string) error {
 	editor := os.Getenv("EDITOR")
 	if editor == "" {
@@ -0,0 +1,64 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/ipfs/go-ipfs/core/node"
+	"github.com/ipfs/go-ipfs/repo"
+	"github.com/ipfs/go-ipfs/repo/common"
+
+	config "github.com/ipfs/go-ipfs-config"
+)
+
+// keyRepo is a mock repo that can set and get single config keys.
+type keyRepo struct {
+	repo.Mock
+}
+
+func (r *keyRepo) SetConfigKey(key string, value interface{}) error {
+	m, err := config.ToMap(&r.C)
+	if err != nil {
+		return err
+	}
+	if err := common.MapSetKV(m, key, value); err != nil {
+		return err
+	}
+	cfg, err := config.FromMap(m)
+	if err != nil {
+		return err
+	}
+	r.C = *cfg
+	return nil
+}
+
+func (r *keyRepo) GetConfigKey(key string) (interface{}, error) {
+	m, err := config.ToMap(&r.C)
+	if err != nil {
+		return nil, err
+	}
+	return common.MapGetKV(m, key)
+}
+
+func TestSetAppliedConfig(t *testing.T) {
+	r := &keyRepo{}
+	r.C.DNS.ProofCache.TTL = "1m"
+	apply := func(cfg *config.Config) error {
+		return node.ApplyDNSConfig(nil, cfg.DNS)
+	}
+
+	for _, value := range []string{"0s", "-1m", "soon"} {
+		if _, err := setAppliedConfig(r, "DNS.ProofCache.TTL", value, apply); err == nil {
+			t.Fatalf("expected %q to be rejected", value)
+		}
+		if r.C.DNS.ProofCache.TTL != "1m" {
+			t.Fatalf("rejected value %q was saved as %q", value, r.C.DNS.ProofCache.TTL)
+		}
+	}
+
+	if _, err := setAppliedConfig(r, "DNS.ProofCache.TTL", "2m", apply); err != nil {
+		t.Fatal(err)
+	}
+	if r.C.DNS.ProofCache.TTL != "2m" {
+		t.Fatalf("expected the TTL to be set to 2m, got %q", r.C.DNS.ProofCache.TTL)
+	}
+}
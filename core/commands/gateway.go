@@ -0,0 +1,73 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	sampling "github.com/ipfs/go-ipfs/core/corehttp/sampling"
+
+	cmds "github.com/ipfs/go-ipfs-cmds"
+)
+
+var errNoGatewaySampler = errors.New("gateway sampling is disabled (is Gateway.Sampling.Rate set?)")
+
+const gatewaySamplesCountOptionName = "count"
+
+var GatewayCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Inspect the HTTP gateway.",
+	},
+
+	Subcommands: map[string]*cmds.Command{
+		"samples": gatewaySamplesCmd,
+	},
+}
+
+var gatewaySamplesCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Pull sampled gateway requests for review.",
+		ShortDescription: `
+When Gateway.Sampling.Rate is set, the gateway records that fraction of
+requests: the CID served, the requested path, host and referer, and a hash of
+the user agent. 'ipfs gateway samples' removes the oldest recorded requests
+from the queue and prints them, one per line, so that moderation tooling can
+review them.
+`,
+	},
+	Options: []cmds.Option{
+		cmds.IntOption(gatewaySamplesCountOptionName, "n", "Maximum number of samples to pull. Pulls all of them by default."),
+	},
+	Type: sampling.Sample{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		if nd.GatewaySampler == nil {
+			return errNoGatewaySampler
+		}
+
+		count, _ := req.Options[gatewaySamplesCountOptionName].(int)
+		if count < 0 {
+			return errors.New("count must not be negative")
+		}
+
+		for _, s := range nd.GatewaySampler.Pull(count) {
+			s := s
+			if err := res.Emit(&s); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, s *sampling.Sample) error {
+			_, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", s.Time.Format(time.RFC3339), s.Cid, s.Host, s.Path, s.Referer, s.UserAgentHash)
+			return err
+		}),
+	},
+}
@@ -1,14 +1,18 @@
 package name
 
 import (
+	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
 	namesys "github.com/ipfs/go-ipfs/namesys"
+	dnssec "github.com/ipfs/go-ipfs/namesys/dnssec"
 
 	cmds "github.com/ipfs/go-ipfs-cmds"
 	logging "github.com/ipfs/go-log"
@@ -21,6 +25,14 @@ var log = logging.Logger("core/commands/ipns")
 
 type ResolvedPath struct {
 	Path path.Path
+
+	// Proof holds the DNSSEC proof chunks, when requested with --with-proof.
+	Proof [][]byte `json:",omitempty"`
+	// DNSSEC holds the lookups that failed validation, when requested with
+	// --verbose.
+	DNSSEC []*dnssec.ChainError `json:",omitempty"`
+	// Timings holds how long each stage took, when requested with --timing.
+	Timings []namesys.Timing `json:",omitempty"`
 }
 
 const (
@@ -29,6 +41,9 @@ const (
 	dhtRecordCountOptionName = "dht-record-count"
 	dhtTimeoutOptionName     = "dht-timeout"
 	streamOptionName         = "stream"
+	withProofOptionName      = "with-proof"
+	verboseOptionName        = "verbose"
+	timingOptionName         = "timing"
 )
 
 var IpnsCmd = &cmds.Command{
@@ -66,6 +81,20 @@ Resolve the value of a dnslink:
   > ipfs name resolve ipfs.io
   /ipfs/QmaBvfZooxWkrv7D3r8LS9moNjzD2o525XMZze69hhoxf5
 
+Resolve a dnslink with a DNSSEC proof, and show which link of the chain of
+trust failed if it can't be validated:
+
+  > ipfs name resolve --with-proof --verbose example.com
+  /ipfs/QmaBvfZooxWkrv7D3r8LS9moNjzD2o525XMZze69hhoxf5
+  ...
+
+Show where the time went while resolving a dnslink:
+
+  > ipfs name resolve --timing ipfs.io
+  /ipfs/QmaBvfZooxWkrv7D3r8LS9moNjzD2o525XMZze69hhoxf5
+  timing: dns  ipfs.io.           21.3ms
+  timing: dns  _dnslink.ipfs.io.  24.9ms
+
 `,
 	},
 
@@ -78,6 +107,9 @@ Resolve the value of a dnslink:
 		cmds.UintOption(dhtRecordCountOptionName, "dhtrc", "Number of records to request for DHT resolution."),
 		cmds.StringOption(dhtTimeoutOptionName, "dhtt", "Max time to collect values during DHT resolution eg \"30s\". Pass 0 for no timeout."),
 		cmds.BoolOption(streamOptionName, "s", "Stream entries as they are found."),
+		cmds.BoolOption(withProofOptionName, "Resolve dnslinks with DNSSEC and output the proof."),
+		cmds.BoolOption(verboseOptionName, "v", "Report dnslink lookups that failed DNSSEC validation. Requires --with-proof."),
+		cmds.BoolOption(timingOptionName, "Report how long each stage of the resolution took."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		api, err := cmdenv.GetApi(env, req)
@@ -102,16 +134,16 @@ Resolve the value of a dnslink:
 		rc, rcok := req.Options[dhtRecordCountOptionName].(int)
 		dhtt, dhttok := req.Options[dhtTimeoutOptionName].(string)
 		stream, _ := req.Options[streamOptionName].(bool)
+		withProof, _ := req.Options[withProofOptionName].(bool)
+		verbose, _ := req.Options[verboseOptionName].(bool)
+		timing, _ := req.Options[timingOptionName].(bool)
 
-		opts := []options.NameResolveOption{
-			options.Name.Cache(!nocache),
-		}
-
+		var ropts []nsopts.ResolveOpt
 		if !recursive {
-			opts = append(opts, options.Name.ResolveOption(nsopts.Depth(1)))
+			ropts = append(ropts, nsopts.Depth(1))
 		}
 		if rcok {
-			opts = append(opts, options.Name.ResolveOption(nsopts.DhtRecordCount(uint(rc))))
+			ropts = append(ropts, nsopts.DhtRecordCount(uint(rc)))
 		}
 		if dhttok {
 			d, err := time.ParseDuration(dhtt)
@@ -121,20 +153,51 @@ Resolve the value of a dnslink:
 			if d < 0 {
 				return errors.New("DHT timeout value must be >= 0")
 			}
-			opts = append(opts, options.Name.ResolveOption(nsopts.DhtTimeout(d)))
+			ropts = append(ropts, nsopts.DhtTimeout(d))
 		}
 
 		if !strings.HasPrefix(name, "/ipns/") {
 			name = "/ipns/" + name
 		}
 
+		ctx := req.Context
+		var timings *namesys.Timings
+		if timing {
+			if stream {
+				return errors.New("--timing can't be combined with --stream")
+			}
+			timings = new(namesys.Timings)
+			ctx = context.WithValue(ctx, "resolve-timings", timings)
+		}
+
+		if verbose && !withProof {
+			return errors.New("--verbose requires --with-proof")
+		}
+		if withProof {
+			if stream {
+				return errors.New("--with-proof can't be combined with --stream")
+			}
+			return resolveWithProof(ctx, res, env, name, !nocache, verbose, recursive, ropts)
+		}
+
+		opts := []options.NameResolveOption{
+			options.Name.Cache(!nocache),
+		}
+		for _, o := range ropts {
+			opts = append(opts, options.Name.ResolveOption(o))
+		}
+
 		if !stream {
-			output, err := api.Name().Resolve(req.Context, name, opts...)
+			output, err := api.Name().Resolve(ctx, name, opts...)
 			if err != nil && (recursive || err != namesys.ErrResolveRecursion) {
 				return err
 			}
 
-			return cmds.EmitOnce(res, &ResolvedPath{path.FromString(output.String())})
+			out := &ResolvedPath{Path: path.FromString(output.String())}
+			if timings != nil {
+				out.Timings = timings.Timings()
+			}
+			return cmds.EmitOnce(res, out)
 		}
 
 		output, err := api.Name().Search(req.Context, name, opts...)
@@ -146,7 +209,7 @@ Resolve the value of a dnslink:
 			if v.Err != nil && (recursive || v.Err != namesys.ErrResolveRecursion) {
 				return v.Err
 			}
-			if err := res.Emit(&ResolvedPath{path.FromString(v.Path.String())}); err != nil {
+			if err := res.Emit(&ResolvedPath{Path: path.FromString(v.Path.String())}); err != nil {
 				return err
 			}
 
@@ -156,9 +219,93 @@ Resolve the value of a dnslink:
 	},
 	Encoders: cmds.EncoderMap{
 		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, rp *ResolvedPath) error {
-			_, err := fmt.Fprintln(w, rp.Path)
-			return err
+			if rp.Path != "" {
+				if _, err := fmt.Fprintln(w, rp.Path); err != nil {
+					return err
+				}
+			}
+			for _, chunk := range rp.Proof {
+				if _, err := fmt.Fprintf(w, "proof: %s\n", base64.StdEncoding.EncodeToString(chunk)); err != nil {
+					return err
+				}
+			}
+			if err := WriteTimings(w, rp.Timings); err != nil {
+				return err
+			}
+			for _, ce := range rp.DNSSEC {
+				fmt.Fprintf(w, "dnssec: %s: %s\n", ce.Name, ce.Error())
+				tw := tabwriter.NewWriter(w, 4, 4, 2, ' ', 0)
+				for _, l := range ce.Chain {
+					status := "ok"
+					if l.Err != "" {
+						status = l.Err
+					}
+					fmt.Fprintf(tw, "  %s\t%s\t%s\n", l.Zone, l.Type, status)
+				}
+				if err := tw.Flush(); err != nil {
+					return err
+				}
+			}
+			return nil
 		}),
 	},
 	Type: ResolvedPath{},
 }
+
+// proofChunks collects the proof written out by the name system.
+type proofChunks [][]byte
+
+func (p *proofChunks) WriteChunk(chunk []byte) error {
+	*p = append(*p, chunk)
+	return nil
+}
+
+// resolveWithProof resolves name through the node's name system directly, as
+// the proof isn't carried through the core API.
+func resolveWithProof(ctx context.Context, res cmds.ResponseEmitter, env cmds.Environment, name string, cache, verbose, recursive bool, ropts []nsopts.ResolveOpt) error {
+	nd, err := cmdenv.GetNode(env)
+	if err != nil {
+		return err
+	}
+
+	if !cache {
+		ctx = namesys.WithoutCache(ctx)
+	}
+
+	var proof proofChunks
+	report := new(dnssec.Report)
+	ctx = context.WithValue(ctx, "proxy-preamble", &proof)
+	ctx = context.WithValue(ctx, "dnssec-report", report)
+
+	p, err := nd.Namesys.Resolve(ctx, name, ropts...)
+	if err != nil && (recursive || err != namesys.ErrResolveRecursion) {
+		if verbose && len(report.Errors()) > 0 {
+			if err := res.Emit(&ResolvedPath{DNSSEC: report.Errors()}); err != nil {
+				return err
+			}
+		}
+		return err
+	}
+
+	out := &ResolvedPath{Path: p, Proof: proof}
+	if verbose {
+		out.DNSSEC = report.Errors()
+	}
+	if timings, ok := ctx.Value("resolve-timings").(*namesys.Timings); ok {
+		out.Timings = timings.Timings()
+	}
+	return cmds.EmitOnce(res, out)
+}
+
+// WriteTimings writes one line per resolution stage to w, as reported by
+// --timing.
+func WriteTimings(w io.Writer, timings []namesys.Timing) error {
+	if len(timings) == 0 {
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 4, 4, 2, ' ', 0)
+	for _, t := range timings {
+		fmt.Fprintf(tw, "timing: %s\t%s\t%s\n", t.Stage, t.Name, t.Duration)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,145 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+
+	cid "github.com/ipfs/go-cid"
+	cmds "github.com/ipfs/go-ipfs-cmds"
+)
+
+var errNoProviderQueue = errors.New("this node has no provide queue (is Experimental.StrategicProviding enabled?)")
+
+var ProvideCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Inspect and manage the queue of content waiting to be announced.",
+		ShortDescription: `
+Blocks added to the node are queued up to be announced to the network as
+provider records. 'ipfs provide' lets you see what is waiting in that queue
+and remove entries before they are announced.
+`,
+	},
+
+	Subcommands: map[string]*cmds.Command{
+		"queue":  provideQueueCmd,
+		"cancel": provideCancelCmd,
+	},
+}
+
+var provideQueueCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Inspect the provide queue.",
+	},
+
+	Subcommands: map[string]*cmds.Command{
+		"ls": provideQueueLsCmd,
+	},
+}
+
+var provideQueueLsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List the CIDs waiting to be announced.",
+		ShortDescription: `
+Prints the CIDs currently in the provide queue, in the order they will be
+announced. CIDs that are already being announced are not listed.
+`,
+	},
+	Type: cid.Cid{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		if nd.ProviderQueue == nil {
+			return errNoProviderQueue
+		}
+
+		keys, err := nd.ProviderQueue.Entries(req.Context)
+		if err != nil {
+			return err
+		}
+
+		for key := range keys {
+			key := key
+			if err := res.Emit(&key); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, key *cid.Cid) error {
+			enc, err := cmdenv.GetLowLevelCidEncoder(req)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintln(w, enc.Encode(*key))
+			return err
+		}),
+	},
+}
+
+// ProvideCancelOutput is the result of removing a CID from the provide queue.
+type ProvideCancelOutput struct {
+	Cid     cid.Cid
+	Removed int
+}
+
+var provideCancelCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Remove CIDs from the provide queue.",
+		ShortDescription: `
+Removes every queued occurrence of the given CIDs from the provide queue, so
+they won't be announced to the network. CIDs that are already being announced
+can't be cancelled, and CIDs will be queued again if they are re-added.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("cid", true, true, "CIDs to remove from the provide queue.").EnableStdin(),
+	},
+	Type: ProvideCancelOutput{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		if nd.ProviderQueue == nil {
+			return errNoProviderQueue
+		}
+
+		cids := make([]cid.Cid, 0, len(req.Arguments))
+		for _, arg := range req.Arguments {
+			c, err := cid.Decode(arg)
+			if err != nil {
+				return err
+			}
+			cids = append(cids, c)
+		}
+
+		for _, c := range cids {
+			n, err := nd.ProviderQueue.Remove(c)
+			if err != nil {
+				return err
+			}
+			if err := res.Emit(&ProvideCancelOutput{Cid: c, Removed: n}); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *ProvideCancelOutput) error {
+			enc, err := cmdenv.GetLowLevelCidEncoder(req)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(w, "removed %d queued announcements for %s\n", out.Removed, enc.Encode(out.Cid))
+			return err
+		}),
+	},
+}
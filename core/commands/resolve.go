@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -23,6 +24,7 @@ const (
 	resolveRecursiveOptionName      = "recursive"
 	resolveDhtRecordCountOptionName = "dht-record-count"
 	resolveDhtTimeoutOptionName     = "dht-timeout"
+	resolveTimingOptionName         = "timing"
 )
 
 var ResolveCmd = &cmds.Command{
@@ -62,6 +64,18 @@ Resolve the value of an IPFS DAG path:
   $ ipfs resolve /ipfs/QmeZy1fGbwgVSrqbfh9fKQrAWgeyRnj7h8fsHS1oy3k99x/beep/boop
   /ipfs/QmYRMjyvAiHKN9UTi8Bzt1HUspmSRD8T8DwxfSMzLgBon1
 
+Show how long each stage took, to tell a slow DNS server or DHT query from a
+slow path traversal:
+
+  $ ipfs resolve --timing /ipns/ipfs.io/docs
+  /ipfs/QmYRMjyvAiHKN9UTi8Bzt1HUspmSRD8T8DwxfSMzLgBon1
+  timing: dns   ipfs.io.                                                    21.3ms
+  timing: dns   _dnslink.ipfs.io.                                           24.9ms
+  timing: path  /ipfs/QmaBvfZooxWkrv7D3r8LS9moNjzD2o525XMZze69hhoxf5/docs  310.2ms
+
+Stages are "dns" and "dnssec" for dnslink lookups, "ipns" for IPNS record
+queries, and "path" for resolving the path inside the DAG.
+
 `,
 	},
 
@@ -72,6 +86,7 @@ Resolve the value of an IPFS DAG path:
 		cmds.BoolOption(resolveRecursiveOptionName, "r", "Resolve until the result is an IPFS name.").WithDefault(true),
 		cmds.IntOption(resolveDhtRecordCountOptionName, "dhtrc", "Number of records to request for DHT resolution."),
 		cmds.StringOption(resolveDhtTimeoutOptionName, "dhtt", "Max time to collect values during DHT resolution eg \"30s\". Pass 0 for no timeout."),
+		cmds.BoolOption(resolveTimingOptionName, "Report how long each stage of the resolution took."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		api, err := cmdenv.GetApi(env, req)
@@ -81,6 +96,20 @@ Resolve the value of an IPFS DAG path:
 
 		name := req.Arguments[0]
 		recursive, _ := req.Options[resolveRecursiveOptionName].(bool)
+		timing, _ := req.Options[resolveTimingOptionName].(bool)
+
+		ctx := req.Context
+		var timings *ns.Timings
+		if timing {
+			timings = new(ns.Timings)
+			ctx = context.WithValue(ctx, "resolve-timings", timings)
+		}
+		emit := func(out *ncmd.ResolvedPath) error {
+			if timings != nil {
+				out.Timings = timings.Timings()
+			}
+			return cmds.EmitOnce(res, out)
+		}
 
 		var enc cidenc.Encoder
 		switch {
@@ -120,16 +149,16 @@ Resolve the value of an IPFS DAG path:
 				}
 				ropts = append(ropts, options.Name.ResolveOption(nsopts.DhtTimeout(d)))
 			}
-			p, err := api.Name().Resolve(req.Context, name, ropts...)
+			p, err := api.Name().Resolve(ctx, name, ropts...)
 			// ErrResolveRecursion is fine
 			if err != nil && err != ns.ErrResolveRecursion {
 				return err
 			}
-			return cmds.EmitOnce(res, &ncmd.ResolvedPath{Path: ipfspath.Path(p.String())})
+			return emit(&ncmd.ResolvedPath{Path: ipfspath.Path(p.String())})
 		}
 
 		// else, ipfs path or ipns with recursive flag
-		rp, err := api.ResolvePath(req.Context, path.New(name))
+		rp, err := api.ResolvePath(ctx, path.New(name))
 		if err != nil {
 			return err
 		}
@@ -139,12 +168,12 @@ Resolve the value of an IPFS DAG path:
 			encoded += "/" + remainder
 		}
 
-		return cmds.EmitOnce(res, &ncmd.ResolvedPath{Path: ipfspath.Path(encoded)})
+		return emit(&ncmd.ResolvedPath{Path: ipfspath.Path(encoded)})
 	},
 	Encoders: cmds.EncoderMap{
 		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, rp *ncmd.ResolvedPath) error {
 			fmt.Fprintln(w, rp.Path.String())
-			return nil
+			return ncmd.WriteTimings(w, rp.Timings)
 		}),
 	},
 	Type: ncmd.ResolvedPath{},
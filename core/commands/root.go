@@ -119,6 +119,7 @@ var rootSubcommands = map[string]*cmds.Command{
 	"commands":  CommandsDaemonCmd,
 	"files":     FilesCmd,
 	"filestore": FileStoreCmd,
+	"gateway":   GatewayCmd,
 	"get":       GetCmd,
 	"pubsub":    PubsubCmd,
 	"repo":      RepoCmd,
@@ -139,6 +140,7 @@ var rootSubcommands = map[string]*cmds.Command{
 	"pin":       PinCmd,
 	"ping":      PingCmd,
 	"p2p":       P2PCmd,
+	"provide":   ProvideCmd,
 	"refs":      RefsCmd,
 	"resolve":   ResolveCmd,
 	"swarm":     SwarmCmd,
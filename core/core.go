@@ -20,6 +20,7 @@ import (
 	bstore "github.com/ipfs/go-ipfs-blockstore"
 	exchange "github.com/ipfs/go-ipfs-exchange-interface"
 	"github.com/ipfs/go-ipfs-provider"
+	provq "github.com/ipfs/go-ipfs-provider/queue"
 	ipld "github.com/ipfs/go-ipld-format"
 	logging "github.com/ipfs/go-log"
 	mfs "github.com/ipfs/go-mfs"
@@ -41,6 +42,7 @@ import (
 	p2pbhost "github.com/libp2p/go-libp2p/p2p/host/basic"
 
 	"github.com/ipfs/go-ipfs/core/bootstrap"
+	"github.com/ipfs/go-ipfs/core/corehttp/sampling"
 	"github.com/ipfs/go-ipfs/core/node"
 	"github.com/ipfs/go-ipfs/core/node/libp2p"
 	"github.com/ipfs/go-ipfs/fuse/mount"
@@ -77,17 +79,19 @@ type IpfsNode struct {
 	Resolver        *resolver.Resolver        // the path resolution system
 	Reporter        *metrics.BandwidthCounter `optional:"true"`
 	Discovery       discovery.Service         `optional:"true"`
+	GatewaySampler  *sampling.Sampler         `optional:"true"` // gateway requests picked for moderation review
 	FilesRoot       *mfs.Root
 	RecordValidator record.Validator
 
 	// Online
-	PeerHost     p2phost.Host        `optional:"true"` // the network host (server+client)
-	Bootstrapper io.Closer           `optional:"true"` // the periodic bootstrapper
-	Routing      routing.Routing     `optional:"true"` // the routing system. recommend ipfs-dht
-	Exchange     exchange.Interface  // the block exchange + strategy (bitswap)
-	Namesys      namesys.NameSystem  // the name system, resolves paths to hashes
-	Provider     provider.System     // the value provider system
-	IpnsRepub    *ipnsrp.Republisher `optional:"true"`
+	PeerHost      p2phost.Host        `optional:"true"` // the network host (server+client)
+	Bootstrapper  io.Closer           `optional:"true"` // the periodic bootstrapper
+	Routing       routing.Routing     `optional:"true"` // the routing system. recommend ipfs-dht
+	Exchange      exchange.Interface  // the block exchange + strategy (bitswap)
+	Namesys       namesys.NameSystem  // the name system, resolves paths to hashes
+	Provider      provider.System     // the value provider system
+	ProviderQueue *provq.Queue        `optional:"true"` // cids waiting to be provided
+	IpnsRepub     *ipnsrp.Republisher `optional:"true"`
 
 	AutoNAT  *autonat.AutoNATService    `optional:"true"`
 	PubSub   *pubsub.PubSub             `optional:"true"`
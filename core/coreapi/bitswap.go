@@ -0,0 +1,62 @@
+package coreapi
+
+import (
+	"context"
+	"fmt"
+
+	bitswap "github.com/ipfs/go-bitswap"
+	bswl "github.com/ipfs/go-bitswap/wantlist"
+	coreiface "github.com/ipfs/interface-go-ipfs-core"
+)
+
+type BitswapAPI CoreAPI
+
+func (api *BitswapAPI) Wantlist(ctx context.Context) ([]coreiface.WantlistEntry, error) {
+	bs, err := api.bitswap()
+	if err != nil {
+		return nil, err
+	}
+	return wantlistEntries(bs.GetWantlistInfo()), nil
+}
+
+func (api *BitswapAPI) SessionWantlist(ctx context.Context, ses uint64) ([]coreiface.WantlistEntry, error) {
+	bs, err := api.bitswap()
+	if err != nil {
+		return nil, err
+	}
+	return wantlistEntries(bs.GetSessionWantlistInfo(ses)), nil
+}
+
+func (api *BitswapAPI) WantlistSessions(ctx context.Context) (map[uint64]int, error) {
+	bs, err := api.bitswap()
+	if err != nil {
+		return nil, err
+	}
+	return bs.GetWantlistSessions(), nil
+}
+
+func (api *BitswapAPI) bitswap() (*bitswap.Bitswap, error) {
+	err := api.checkOnline(false)
+	if err != nil {
+		return nil, err
+	}
+
+	bs, ok := api.exchange.(*bitswap.Bitswap)
+	if !ok {
+		return nil, fmt.Errorf("exchange is not bitswap: %T", api.exchange)
+	}
+	return bs, nil
+}
+
+func wantlistEntries(infos []bswl.EntryInfo) []coreiface.WantlistEntry {
+	out := make([]coreiface.WantlistEntry, 0, len(infos))
+	for _, info := range infos {
+		out = append(out, coreiface.WantlistEntry{
+			Cid:      info.Cid,
+			Priority: info.Priority,
+			Added:    info.Added,
+			Sessions: info.Sessions,
+		})
+	}
+	return out
+}
@@ -143,6 +143,11 @@ func (api *CoreAPI) PubSub() coreiface.PubSubAPI {
 	return (*PubSubAPI)(api)
 }
 
+// Bitswap returns the BitswapAPI interface implementation backed by the go-ipfs node
+func (api *CoreAPI) Bitswap() coreiface.BitswapAPI {
+	return (*BitswapAPI)(api)
+}
+
 // WithOptions returns api with global options applied
 func (api *CoreAPI) WithOptions(opts ...options.ApiOption) (coreiface.CoreAPI, error) {
 	settings := api.parentOpts // make sure to copy
@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	gopath "path"
+	"time"
 
+	"github.com/ipfs/go-ipfs/namesys"
 	"github.com/ipfs/go-ipfs/namesys/resolve"
 
 	"github.com/ipfs/go-cid"
@@ -65,7 +67,9 @@ func (api *CoreAPI) ResolvePath(ctx context.Context, p path.Path) (path.Resolved
 		ResolveOnce: resolveOnce,
 	}
 
+	start := time.Now()
 	node, rest, err := r.ResolveToLastNode(ctx, ipath)
+	namesys.RecordTiming(ctx, namesys.TimingPath, ipath.String(), start)
 	if err != nil {
 		return nil, err
 	}
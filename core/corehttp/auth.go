@@ -0,0 +1,30 @@
+package corehttp
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+
+	core "github.com/ipfs/go-ipfs/core"
+)
+
+// BearerTokenOption requires requests to send token in an
+// "Authorization: Bearer" header. Requests that don't are answered with 401
+// Unauthorized.
+func BearerTokenOption(token string) ServeOption {
+	want := []byte("Bearer " + token)
+
+	return ServeOption(func(n *core.IpfsNode, l net.Listener, parent *http.ServeMux) (*http.ServeMux, error) {
+		mux := http.NewServeMux()
+		parent.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			got := []byte(r.Header.Get("Authorization"))
+			if subtle.ConstantTimeCompare(got, want) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			mux.ServeHTTP(w, r)
+		})
+		return mux, nil
+	})
+}
@@ -0,0 +1,43 @@
+package corehttp
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	core "github.com/ipfs/go-ipfs/core"
+)
+
+func TestBearerTokenOption(t *testing.T) {
+	h, err := makeHandler(nil, nil,
+		BearerTokenOption("s3cret"),
+		func(_ *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+			mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+			return mux, nil
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		auth   string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"s3cret", http.StatusUnauthorized},
+		{"Bearer s3cret", http.StatusOK},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/debug/metrics/prometheus", nil)
+		if test.auth != "" {
+			r.Header.Set("Authorization", test.auth)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("Authorization %q: got status %d, expected %d", test.auth, w.Code, test.status)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package corehttp
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	core "github.com/ipfs/go-ipfs/core"
+)
+
+// ForwardedForOption makes requests that come through one of the trusted
+// proxies, given as IP addresses or CIDR ranges, appear to come from the
+// client reported in their X-Forwarded-For header. Everything served after
+// this option then sees the real client address in the request's RemoteAddr.
+func ForwardedForOption(trusted []string) ServeOption {
+	return ServeOption(func(n *core.IpfsNode, l net.Listener, parent *http.ServeMux) (*http.ServeMux, error) {
+		nets, err := parseTrustedProxies(trusted)
+		if err != nil {
+			return nil, err
+		}
+
+		mux := http.NewServeMux()
+		parent.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			if ip := forwardedClient(r, nets); ip != nil {
+				r.RemoteAddr = net.JoinHostPort(ip.String(), "0")
+			}
+			mux.ServeHTTP(w, r)
+		})
+		return mux, nil
+	})
+}
+
+func parseTrustedProxies(trusted []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(trusted))
+	for _, t := range trusted {
+		if !strings.Contains(t, "/") {
+			ip := net.ParseIP(t)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy address: %q", t)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(t)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy range: %s", err)
+		}
+		nets = append(nets, ipnet)
+	}
+	return nets, nil
+}
+
+// forwardedClient returns the address of the client r was forwarded for, or
+// nil if r didn't come through a trusted proxy. X-Forwarded-For is read from
+// the right, skipping the proxies we trust, since anything left of them could
+// have been made up by the client.
+func forwardedClient(r *http.Request, trusted []*net.IPNet) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isTrusted(ip, trusted) {
+		return nil
+	}
+
+	var hops []string
+	for _, h := range r.Header["X-Forwarded-For"] {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+
+	for i := len(hops) - 1; i >= 0 && isTrusted(ip, trusted); i-- {
+		next := net.ParseIP(strings.TrimSpace(hops[i]))
+		if next == nil {
+			break
+		}
+		ip = next
+	}
+	return ip
+}
+
+func isTrusted(ip net.IP, trusted []*net.IPNet) bool {
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
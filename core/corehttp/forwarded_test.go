@@ -0,0 +1,47 @@
+package corehttp
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForwardedClient(t *testing.T) {
+	trusted, err := parseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, test := range []struct {
+		remote string
+		xff    []string
+		client string
+	}{
+		// untrusted peers are taken at their word
+		{"198.51.100.7:1234", []string{"203.0.113.9"}, ""},
+		{"192.0.2.1:1234", nil, "192.0.2.1"},
+		{"192.0.2.1:1234", []string{"203.0.113.9"}, "203.0.113.9"},
+		{"10.1.2.3:1234", []string{"203.0.113.9, 10.4.5.6"}, "203.0.113.9"},
+		{"10.1.2.3:1234", []string{"203.0.113.9", "10.4.5.6"}, "203.0.113.9"},
+		// entries left of the first untrusted hop may be spoofed
+		{"10.1.2.3:1234", []string{"1.2.3.4, 203.0.113.9"}, "203.0.113.9"},
+		{"10.1.2.3:1234", []string{"garbage"}, "10.1.2.3"},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remote
+		for _, h := range test.xff {
+			r.Header.Add("X-Forwarded-For", h)
+		}
+
+		got := ""
+		if ip := forwardedClient(r, trusted); ip != nil {
+			got = ip.String()
+		}
+		if got != test.client {
+			t.Errorf("(%d) got client %q, expected %q", i, got, test.client)
+		}
+	}
+
+	if _, err := parseTrustedProxies([]string{"not-an-ip"}); err == nil {
+		t.Fatal("expected an invalid address to be rejected")
+	}
+}
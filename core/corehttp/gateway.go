@@ -5,11 +5,14 @@ import (
 	"net"
 	"net/http"
 	"sort"
+	"time"
 
 	version "github.com/ipfs/go-ipfs"
 	core "github.com/ipfs/go-ipfs/core"
 	coreapi "github.com/ipfs/go-ipfs/core/coreapi"
+	sampling "github.com/ipfs/go-ipfs/core/corehttp/sampling"
 
+	config "github.com/ipfs/go-ipfs-config"
 	options "github.com/ipfs/interface-go-ipfs-core/options"
 	id "github.com/libp2p/go-libp2p/p2p/protocol/identify"
 )
@@ -18,8 +21,20 @@ type GatewayConfig struct {
 	Headers      map[string][]string
 	Writable     bool
 	PathPrefixes []string
+
+	ContentTypes     config.ContentTypeFilter
+	HostContentTypes map[string]config.ContentTypeFilter
+
+	// IPNSMaxAge caps the max-age of responses for /ipns/ paths.
+	IPNSMaxAge time.Duration
+
+	// Sampler, if set, records a fraction of requests for review.
+	Sampler *sampling.Sampler
 }
 
+// DefaultIPNSMaxAge is the default for the Gateway.IPNSMaxAge config setting.
+const DefaultIPNSMaxAge = 6 * time.Hour
+
 // A helper function to clean up a set of headers:
 // 1. Canonicalizes.
 // 2. Deduplicates.
@@ -87,10 +102,27 @@ func GatewayOption(writable bool, paths ...string) ServeOption {
 				"X-Stream-Output",
 			}, headers[ACEHeadersName]...))
 
+		ipnsMaxAge := DefaultIPNSMaxAge
+		if cfg.Gateway.IPNSMaxAge != "" {
+			ipnsMaxAge, err = time.ParseDuration(cfg.Gateway.IPNSMaxAge)
+			if err != nil {
+				return nil, fmt.Errorf("failure to parse config setting Gateway.IPNSMaxAge: %s", err)
+			}
+			if ipnsMaxAge < 0 {
+				return nil, fmt.Errorf("config setting Gateway.IPNSMaxAge is negative: %s", ipnsMaxAge)
+			}
+		}
+
 		gateway := newGatewayHandler(GatewayConfig{
 			Headers:      headers,
 			Writable:     writable,
 			PathPrefixes: cfg.Gateway.PathPrefixes,
+
+			ContentTypes:     cfg.Gateway.ContentTypes,
+			HostContentTypes: cleanContentTypeFilters(cfg.Gateway.HostContentTypes),
+
+			IPNSMaxAge: ipnsMaxAge,
+			Sampler:    n.GatewaySampler,
 		}, api)
 
 		for _, p := range paths {
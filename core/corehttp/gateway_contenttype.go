@@ -0,0 +1,71 @@
+package corehttp
+
+import (
+	"errors"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+
+	config "github.com/ipfs/go-ipfs-config"
+)
+
+// errContentTypeNotAllowed is returned when the gateway is configured not to
+// serve content of the requested type.
+var errContentTypeNotAllowed = errors.New("content type is not allowed on this gateway")
+
+// contentTypeAllowed reports whether a response of type ctype may be served
+// for the request r, given the gateway's content type restrictions.
+func (i *gatewayHandler) contentTypeAllowed(r *http.Request, ctype string) bool {
+	filter := i.config.ContentTypes
+	if len(i.config.HostContentTypes) > 0 {
+		if hf, ok := i.config.HostContentTypes[requestHostname(r)]; ok {
+			filter = hf
+		}
+	}
+
+	mediatype, _, err := mime.ParseMediaType(ctype)
+	if err != nil {
+		mediatype = strings.ToLower(strings.TrimSpace(ctype))
+	}
+
+	if matchContentType(filter.Deny, mediatype) {
+		return false
+	}
+	return len(filter.Allow) == 0 || matchContentType(filter.Allow, mediatype)
+}
+
+// requestHostname returns the lower-cased hostname of r, without any port or
+// IPv6 brackets.
+func requestHostname(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = strings.TrimSuffix(strings.TrimPrefix(r.Host, "["), "]")
+	}
+	return strings.ToLower(host)
+}
+
+// matchContentType returns true if mediatype matches any of patterns. A
+// pattern is either a full media type or a "type/*" wildcard.
+func matchContentType(patterns []string, mediatype string) bool {
+	for _, p := range patterns {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == "*/*" || p == mediatype {
+			return true
+		}
+		if strings.HasSuffix(p, "/*") && strings.HasPrefix(mediatype, strings.TrimSuffix(p, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanContentTypeFilters lowercases the hostnames of per-host filters so they
+// can be matched against the request's Host header.
+func cleanContentTypeFilters(in map[string]config.ContentTypeFilter) map[string]config.ContentTypeFilter {
+	out := make(map[string]config.ContentTypeFilter, len(in))
+	for host, f := range in {
+		out[strings.ToLower(host)] = f
+	}
+	return out
+}
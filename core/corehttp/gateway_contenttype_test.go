@@ -0,0 +1,45 @@
+package corehttp
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	config "github.com/ipfs/go-ipfs-config"
+)
+
+func TestContentTypeAllowed(t *testing.T) {
+	gw := newGatewayHandler(GatewayConfig{
+		ContentTypes: config.ContentTypeFilter{
+			Deny: []string{"text/html"},
+		},
+		HostContentTypes: cleanContentTypeFilters(map[string]config.ContentTypeFilter{
+			"Media.Example.com": {Allow: []string{"image/*", "video/mp4"}},
+			"2001:db8::1":       {Allow: []string{"image/*"}},
+		}),
+	}, nil)
+
+	for _, test := range []struct {
+		host, ctype string
+		allowed     bool
+	}{
+		{"example.com", "text/plain; charset=utf-8", true},
+		{"example.com", "text/html; charset=utf-8", false},
+		{"example.com", "TEXT/HTML", false},
+		{"example.com", "image/png", true},
+		{"media.example.com", "image/png", true},
+		{"media.example.com:8080", "image/svg+xml", true},
+		{"media.example.com", "video/mp4", true},
+		{"media.example.com", "video/webm", false},
+		{"media.example.com", "text/plain", false},
+		{"[2001:db8::1]:8080", "image/png", true},
+		{"[2001:db8::1]:8080", "text/plain", false},
+		{"[2001:db8::1]", "text/plain", false},
+		{"[2001:db8::2]:8080", "text/plain", true},
+	} {
+		r := httptest.NewRequest("GET", "/ipfs/QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn", nil)
+		r.Host = test.host
+		if got := gw.contentTypeAllowed(r, test.ctype); got != test.allowed {
+			t.Errorf("%s %q: expected allowed=%t, got %t", test.host, test.ctype, test.allowed, got)
+		}
+	}
+}
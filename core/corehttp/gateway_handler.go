@@ -18,6 +18,7 @@ import (
 	"github.com/dustin/go-humanize"
 	"github.com/ipfs/go-cid"
 	files "github.com/ipfs/go-ipfs-files"
+	"github.com/ipfs/go-ipfs/namesys"
 	dag "github.com/ipfs/go-merkledag"
 	"github.com/ipfs/go-mfs"
 	"github.com/ipfs/go-path"
@@ -179,7 +180,12 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 
 	// Resolve path to the final DAG node for the ETag
 	ipfsCacheTag := ""
-	resolvedPath, err := i.api.ResolvePath(context.WithValue(r.Context(), "cache-tag", &ipfsCacheTag), parsedPath)
+	var ttl time.Duration
+
+	resolveCtx := context.WithValue(r.Context(), "cache-tag", &ipfsCacheTag)
+	resolveCtx = context.WithValue(resolveCtx, "cache-ttl", &ttl)
+
+	resolvedPath, err := i.api.ResolvePath(resolveCtx, parsedPath)
 	switch err {
 	case nil:
 	case coreiface.ErrOffline:
@@ -190,6 +196,10 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if i.config.Sampler != nil {
+		i.config.Sampler.Sample(r, resolvedPath.Cid())
+	}
+
 	dr, err := i.api.Unixfs().Get(r.Context(), resolvedPath)
 	if err != nil {
 		webError(w, "ipfs cat "+escapedURLPath, err, http.StatusNotFound)
@@ -234,29 +244,26 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	// Deal with cache headers.
-	etag := "\"" + resolvedPath.Cid().String() + "\""
+	etag := responseEtag("", resolvedPath.Cid(), ipfsCacheTag)
 
 	modtime := time.Now()
 	if strings.HasPrefix(urlPath, ipfsPathPrefix) {
 		w.Header().Set("Cache-Control", "public, max-age=29030400, immutable")
 		// set modtime to a really long time ago, since files are immutable and should stay cached
 		modtime = time.Unix(1, 0)
+	} else {
+		w.Header().Set("Cache-Control", i.ipnsCacheControl(ttl))
 	}
 
 	w.Header().Set("Vary", "X-Ipfs-Secure-Gateway, Service-Worker")
 	w.Header().Set("Etag", etag)
-	w.Header().Set("Cache-Tag", etag)
+	w.Header().Set("Cache-Tag", "\""+resolvedPath.Cid().String()+"\"")
 	w.Header().Set("X-IPFS-Path", urlPath)
 	if ipfsCacheTag != "" {
 		w.Header().Set("X-Ipfs-Cache-Tag", ipfsCacheTag)
 	}
 	i.addUserHeaders(w) // ok, _now_ write user's headers.
 
-	if r.Header.Get("If-None-Match") == etag || r.Header.Get("If-None-Match") == "W/"+etag {
-		w.WriteHeader(http.StatusNotModified)
-		return
-	}
-
 	if f, ok := dr.(files.File); ok {
 		urlFilename := r.URL.Query().Get("filename")
 		var name string
@@ -266,7 +273,7 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		} else {
 			name = getFilename(urlPath)
 		}
-		i.serveFile(w, r, name, modtime, f)
+		i.serveFile(w, r, name, modtime, etag, f)
 		return
 	}
 	dir, ok := dr.(files.Directory)
@@ -293,7 +300,7 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		}
 
 		// write to request
-		i.serveFile(w, r, "index.html", modtime, f)
+		i.serveFile(w, r, "index.html", modtime, etag, f)
 		return
 	case resolver.ErrNoLink:
 		// no index.html; noop
@@ -302,6 +309,11 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
 	w.Header().Set("Content-Type", "text/html")
 	if r.Method == http.MethodHead {
 		return
@@ -426,9 +438,11 @@ func (i *gatewayHandler) secureGetHandler(w http.ResponseWriter, r *http.Request
 	// Resolve path to the final DAG node for the ETag
 	preamble := &proofBuffer{}
 	ipfsCacheTag := ""
+	var ttl time.Duration
 
 	resolveCtx := context.WithValue(r.Context(), "proxy-preamble", preamble)
 	resolveCtx = context.WithValue(resolveCtx, "cache-tag", &ipfsCacheTag)
+	resolveCtx = context.WithValue(resolveCtx, "cache-ttl", &ttl)
 
 	resolvedPath, err := i.api.ResolvePath(resolveCtx, parsedPath)
 	switch err {
@@ -441,6 +455,10 @@ func (i *gatewayHandler) secureGetHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if i.config.Sampler != nil {
+		i.config.Sampler.Sample(r, resolvedPath.Cid())
+	}
+
 	pr, err := i.api.Unixfs().GetWithProof(r.Context(), resolvedPath)
 	if err == uio.ErrIsDir {
 		http.Redirect(w, r, gopath.Join(originalUrlPath, "index.html"), 302)
@@ -455,9 +473,8 @@ func (i *gatewayHandler) secureGetHandler(w http.ResponseWriter, r *http.Request
 	defer pr.Close()
 
 	// Set Content-Type by file extension, or manually sniff if that doesn't work
-	if contentType := mime.TypeByExtension(gopath.Ext(urlPath)); contentType != "" {
-		w.Header().Set("Content-Type", contentType)
-	} else {
+	contentType := mime.TypeByExtension(gopath.Ext(urlPath))
+	if contentType == "" {
 		dr, err := i.api.Unixfs().Get(r.Context(), resolvedPath)
 		if err != nil {
 			webError(w, "ipfs cat "+escapedURLPath, err, http.StatusNotFound)
@@ -476,19 +493,26 @@ func (i *gatewayHandler) secureGetHandler(w http.ResponseWriter, r *http.Request
 		}
 		dr.Close()
 
-		w.Header().Set("Content-Type", http.DetectContentType(sample[:n]))
+		contentType = http.DetectContentType(sample[:n])
 	}
+	if !i.contentTypeAllowed(r, contentType) {
+		webErrorWithCode(w, "ipfs cat "+escapedURLPath, errContentTypeNotAllowed, http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
 
 	// Deal with cache headers.
-	etag := "\"sec-" + resolvedPath.Cid().String()
 	cacheTag := "\"" + resolvedPath.Cid().String() + "\""
 
+	var etag string
 	if strings.HasPrefix(urlPath, ipfsPathPrefix) {
-		etag += "\""
+		etag = responseEtag("sec-", resolvedPath.Cid(), "")
 		w.Header().Set("Cache-Control", "public, max-age=29030400, immutable")
 	} else {
-		etag += "-" + fmt.Sprint(time.Now().UnixNano()/int64(11*time.Hour)) + "\""
-		w.Header().Set("Cache-Control", "public, max-age=21600")
+		// The preamble carries signatures that expire, so the tag also
+		// rotates periodically.
+		etag = responseEtag("sec-", resolvedPath.Cid(), ipfsCacheTag, fmt.Sprint(time.Now().UnixNano()/int64(11*time.Hour)))
+		w.Header().Set("Cache-Control", i.ipnsCacheControl(ttl))
 	}
 
 	w.Header().Set("Vary", "X-Ipfs-Secure-Gateway, Service-Worker")
@@ -500,7 +524,7 @@ func (i *gatewayHandler) secureGetHandler(w http.ResponseWriter, r *http.Request
 	}
 	i.addUserHeaders(w) // ok, _now_ write user's headers.
 
-	if r.Header.Get("If-None-Match") == etag || r.Header.Get("If-None-Match") == "W/"+etag {
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
 		w.WriteHeader(http.StatusNotModified)
 		return
 	}
@@ -512,6 +536,55 @@ func (i *gatewayHandler) secureGetHandler(w http.ResponseWriter, r *http.Request
 	}
 }
 
+// ipnsCacheControl returns the Cache-Control header for a response to an
+// /ipns/ path whose name resolved with the given remaining TTL. The max-age
+// follows the TTL, capped by the IPNSMaxAge setting. Names resolved without a
+// TTL, such as dnslinks looked up without DNSSEC, get the short
+// namesys.DefaultResolverCacheTTL instead.
+func (i *gatewayHandler) ipnsCacheControl(ttl time.Duration) string {
+	if ttl <= 0 {
+		ttl = namesys.DefaultResolverCacheTTL
+	}
+	maxAge := i.config.IPNSMaxAge
+	if ttl < maxAge {
+		maxAge = ttl
+	}
+	return fmt.Sprintf("public, max-age=%d", int64(maxAge/time.Second))
+}
+
+// responseEtag builds a strong ETag from the resolved CID, followed by the
+// cache tag of the dnslink records the path was resolved through, if any, and
+// any extra components. A dnslink update thus changes the tag even when it
+// points back to content that was served before under another name.
+func responseEtag(prefix string, c cid.Cid, cacheTag string, extra ...string) string {
+	tag := prefix + c.String()
+	if cacheTag != "" {
+		tag += "-" + cacheTag
+	}
+	for _, e := range extra {
+		tag += "-" + e
+	}
+	return "\"" + tag + "\""
+}
+
+// etagMatch reports whether the If-None-Match header value matches etag. The
+// header may list several tags, and uses weak comparison (RFC 7232, 3.2).
+func etagMatch(ifNoneMatch, etag string) bool {
+	ifNoneMatch = strings.TrimSpace(ifNoneMatch)
+	if ifNoneMatch == "" {
+		return false
+	}
+	if ifNoneMatch == "*" {
+		return true
+	}
+	for _, t := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(t), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
 // proofBuffer is an in-memory implementation of ProofWriter and ProofReader for
 // the gateway's preamble, where proofs of name resolution are provided.
 type proofBuffer struct {
@@ -569,7 +642,10 @@ func copyChunks(w io.Writer, pr coreiface.ProofReader) error {
 	}
 }
 
-func (i *gatewayHandler) serveFile(w http.ResponseWriter, req *http.Request, name string, modtime time.Time, file files.File) {
+// serveFile writes file as the response, or a 304 if the request's
+// If-None-Match matches etag. The content type filter applies either way, so
+// a cached copy of content that is no longer allowed isn't revalidated.
+func (i *gatewayHandler) serveFile(w http.ResponseWriter, req *http.Request, name string, modtime time.Time, etag string, file files.File) {
 	size, err := file.Size()
 	if err != nil {
 		http.Error(w, "cannot serve files with unknown sizes", http.StatusBadGateway)
@@ -606,8 +682,17 @@ func (i *gatewayHandler) serveFile(w http.ResponseWriter, req *http.Request, nam
 			ctype = "text/html"
 		}
 	}
+	if !i.contentTypeAllowed(req, ctype) {
+		http.Error(w, errContentTypeNotAllowed.Error(), http.StatusForbidden)
+		return
+	}
 	w.Header().Set("Content-Type", ctype)
 
+	if etagMatch(req.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
 	http.ServeContent(w, req, name, modtime, content)
 }
 
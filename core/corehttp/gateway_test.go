@@ -560,6 +560,90 @@ func TestCacheControlImmutable(t *testing.T) {
 	}
 }
 
+func TestIPNSCacheControl(t *testing.T) {
+	gw := newGatewayHandler(GatewayConfig{IPNSMaxAge: time.Hour}, nil)
+
+	for _, test := range []struct {
+		ttl    time.Duration
+		header string
+	}{
+		{30 * time.Second, "public, max-age=30"},
+		{2 * time.Hour, "public, max-age=3600"},
+		{0, "public, max-age=60"},
+	} {
+		if got := gw.ipnsCacheControl(test.ttl); got != test.header {
+			t.Errorf("TTL %s: got Cache-Control %q, expected %q", test.ttl, got, test.header)
+		}
+	}
+}
+
+func TestGatewayEtag(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, nil)
+	defer ts.Close()
+
+	k, err := api.Unixfs().Add(ctx, files.NewBytesFile([]byte("fnord")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := "\"" + k.Cid().String() + "\""
+
+	for i, test := range []struct {
+		ifNoneMatch string
+		status      int
+	}{
+		{"", http.StatusOK},
+		{etag, http.StatusNotModified},
+		{"W/" + etag, http.StatusNotModified},
+		{"\"other\", " + etag, http.StatusNotModified},
+		{"*", http.StatusNotModified},
+		{"\"other\"", http.StatusOK},
+	} {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+k.String(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", test.ifNoneMatch)
+		}
+
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != test.status {
+			t.Errorf("(%d) If-None-Match %q: got status %d, expected %d", i, test.ifNoneMatch, res.StatusCode, test.status)
+		}
+		if got := res.Header.Get("Etag"); got != etag {
+			t.Errorf("(%d) got Etag %q, expected %q", i, got, etag)
+		}
+	}
+}
+
+func TestGatewayEtagContentTypeDenied(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, nil)
+	defer ts.Close()
+
+	k, err := api.Unixfs().Add(ctx, files.NewBytesFile([]byte("fnord")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gw := newGatewayHandler(GatewayConfig{
+		ContentTypes: config.ContentTypeFilter{Deny: []string{"text/plain"}},
+	}, api)
+
+	req := httptest.NewRequest(http.MethodGet, k.String(), nil)
+	req.Header.Set("If-None-Match", "\""+k.Cid().String()+"\"")
+	rec := httptest.NewRecorder()
+	gw.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected a denied type to be refused even when cached, got status %d", rec.Code)
+	}
+}
+
 func TestGoGetSupport(t *testing.T) {
 	ts, _, _ := newTestServerAndNode(t, nil)
 	t.Logf("test server url: %s", ts.URL)
@@ -0,0 +1,95 @@
+// Package sampling records a fraction of gateway requests for later review,
+// so that moderation tooling can discover abusive content without waiting for
+// reports.
+package sampling
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// Sample is a gateway request picked for review.
+type Sample struct {
+	Time    time.Time
+	Cid     cid.Cid
+	Path    string
+	Host    string
+	Referer string `json:",omitempty"`
+
+	// UserAgentHash is the hex SHA-256 of the User-Agent header, so that
+	// requests from the same client can be grouped without keeping it.
+	UserAgentHash string `json:",omitempty"`
+}
+
+// Sampler picks gateway requests at random and queues them until they're
+// pulled. When the queue is full, the oldest samples are dropped.
+type Sampler struct {
+	rate float64
+	size int
+
+	lk      sync.Mutex
+	queue   []Sample
+	dropped uint64
+}
+
+// New creates a Sampler that records the given fraction of requests, between
+// 0 and 1, and keeps up to size of them.
+func New(rate float64, size int) *Sampler {
+	return &Sampler{rate: rate, size: size}
+}
+
+// Sample records r, which was served c, if it's picked.
+func (s *Sampler) Sample(r *http.Request, c cid.Cid) {
+	if s.rate <= 0 || s.size <= 0 || rand.Float64() >= s.rate {
+		return
+	}
+
+	sample := Sample{
+		Time:    time.Now(),
+		Cid:     c,
+		Path:    r.URL.Path,
+		Host:    r.Host,
+		Referer: r.Referer(),
+	}
+	if ua := r.UserAgent(); ua != "" {
+		sum := sha256.Sum256([]byte(ua))
+		sample.UserAgentHash = hex.EncodeToString(sum[:])
+	}
+
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if len(s.queue) >= s.size {
+		n := len(s.queue) - s.size + 1
+		s.queue = s.queue[n:]
+		s.dropped += uint64(n)
+	}
+	s.queue = append(s.queue, sample)
+}
+
+// Pull removes and returns up to max of the oldest samples, or all of them if
+// max isn't positive.
+func (s *Sampler) Pull(max int) []Sample {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	n := len(s.queue)
+	if max > 0 && max < n {
+		n = max
+	}
+	out := append([]Sample(nil), s.queue[:n]...)
+	s.queue = s.queue[n:]
+	return out
+}
+
+// Dropped returns how many samples were dropped because the queue was full.
+func (s *Sampler) Dropped() uint64 {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	return s.dropped
+}
@@ -0,0 +1,48 @@
+package sampling
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+func TestSampler(t *testing.T) {
+	c, err := cid.Decode("QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := New(1, 2)
+	for _, p := range []string{"/ipfs/a", "/ipfs/b", "/ipfs/c"} {
+		r := httptest.NewRequest("GET", "http://example.com"+p, nil)
+		r.Header.Set("User-Agent", "test")
+		s.Sample(r, c)
+	}
+
+	if s.Dropped() != 1 {
+		t.Fatalf("expected 1 dropped sample, got %d", s.Dropped())
+	}
+
+	out := s.Pull(1)
+	if len(out) != 1 || out[0].Path != "/ipfs/b" {
+		t.Fatalf("unexpected samples: %+v", out)
+	}
+	if out[0].Host != "example.com" || !out[0].Cid.Equals(c) || len(out[0].UserAgentHash) != 64 {
+		t.Fatalf("unexpected sample: %+v", out[0])
+	}
+
+	out = s.Pull(0)
+	if len(out) != 1 || out[0].Path != "/ipfs/c" {
+		t.Fatalf("unexpected samples: %+v", out)
+	}
+	if out = s.Pull(0); len(out) != 0 {
+		t.Fatalf("expected the queue to be empty, got %+v", out)
+	}
+
+	s = New(0, 2)
+	s.Sample(httptest.NewRequest("GET", "http://example.com/ipfs/a", nil), c)
+	if out = s.Pull(0); len(out) != 0 {
+		t.Fatalf("expected nothing to be sampled, got %+v", out)
+	}
+}
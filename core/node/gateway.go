@@ -0,0 +1,35 @@
+package node
+
+import (
+	"fmt"
+
+	config "github.com/ipfs/go-ipfs-config"
+
+	"github.com/ipfs/go-ipfs/core/corehttp/sampling"
+)
+
+// DefaultGatewaySampleQueueSize is the default for the
+// Gateway.Sampling.QueueSize config setting.
+const DefaultGatewaySampleQueueSize = 1024
+
+// GatewaySampler creates the sampler of gateway requests described by cfg. It
+// returns nil if sampling is disabled.
+func GatewaySampler(cfg config.GatewaySampling) func() (*sampling.Sampler, error) {
+	return func() (*sampling.Sampler, error) {
+		if cfg.Rate < 0 || cfg.Rate > 1 {
+			return nil, fmt.Errorf("config setting Gateway.Sampling.Rate is not between 0 and 1: %v", cfg.Rate)
+		}
+		if cfg.Rate == 0 {
+			return nil, nil
+		}
+
+		size := DefaultGatewaySampleQueueSize
+		if cfg.QueueSize < 0 {
+			return nil, fmt.Errorf("config setting Gateway.Sampling.QueueSize is negative: %d", cfg.QueueSize)
+		} else if cfg.QueueSize > 0 {
+			size = cfg.QueueSize
+		}
+
+		return sampling.New(cfg.Rate, size), nil
+	}
+}
@@ -231,7 +231,7 @@ func Online(bcfg *BuildCfg, cfg *config.Config) fx.Option {
 
 	return fx.Options(
 		fx.Provide(OnlineExchange(shouldBitswapProvide)),
-		fx.Provide(Namesys(ipnsCacheSize)),
+		fx.Provide(Namesys(ipnsCacheSize, cfg.DNS)),
 
 		fx.Invoke(IpnsRepublisher(repubPeriod, recordLifetime)),
 
@@ -246,7 +246,7 @@ func Online(bcfg *BuildCfg, cfg *config.Config) fx.Option {
 func Offline(cfg *config.Config) fx.Option {
 	return fx.Options(
 		fx.Provide(offline.Exchange),
-		fx.Provide(Namesys(0)),
+		fx.Provide(Namesys(0, cfg.DNS)),
 		fx.Provide(offroute.NewOfflineRouter),
 		OfflineProviders(cfg.Experimental.StrategicProviding, cfg.Reprovider.Strategy, cfg.Reprovider.Interval),
 	)
@@ -295,5 +295,7 @@ func IPFS(ctx context.Context, bcfg *BuildCfg) fx.Option {
 		Networked(bcfg, cfg),
 
 		Core(bcfg),
+
+		fx.Provide(GatewaySampler(cfg.Gateway.Sampling)),
 	)
 }
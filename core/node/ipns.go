@@ -2,8 +2,10 @@ package node
 
 import (
 	"fmt"
+	"net"
 	"time"
 
+	"github.com/ipfs/go-ipfs-config"
 	"github.com/ipfs/go-ipfs-util"
 	"github.com/ipfs/go-ipns"
 	"github.com/libp2p/go-libp2p-core/crypto"
@@ -27,10 +29,101 @@ func RecordValidator(ps peerstore.Peerstore) record.Validator {
 }
 
 // Namesys creates new name system
-func Namesys(cacheSize int) func(rt routing.Routing, repo repo.Repo) (namesys.NameSystem, error) {
+func Namesys(cacheSize int, dns config.DNS) func(rt routing.Routing, repo repo.Repo) (namesys.NameSystem, error) {
 	return func(rt routing.Routing, repo repo.Repo) (namesys.NameSystem, error) {
-		return namesys.NewNameSystem(rt, repo.Datastore(), cacheSize), nil
+		ns := namesys.NewNameSystem(rt, repo.Datastore(), cacheSize)
+		if err := ApplyDNSConfig(ns, dns); err != nil {
+			return nil, err
+		}
+		return ns, nil
+	}
+}
+
+// ApplyDNSConfig applies the DNS config section to a name system. It can be
+// called again on a running node when the config changes.
+func ApplyDNSConfig(ns namesys.NameSystem, cfg config.DNS) error {
+	proofCache, err := dnsProofCache(cfg.ProofCache)
+	if err != nil {
+		return err
+	}
+	clientSubnet, err := dnsClientSubnet(cfg.ClientSubnet)
+	if err != nil {
+		return err
+	}
+	if cfg.MaxRecursionDepth < 0 {
+		return fmt.Errorf("config setting DNS.MaxRecursionDepth is negative: %d", cfg.MaxRecursionDepth)
+	} else if uint64(cfg.MaxRecursionDepth) > namesys.MaxDNSLinkDepthLimit {
+		return fmt.Errorf("config setting DNS.MaxRecursionDepth is more than %d: %d", uint64(namesys.MaxDNSLinkDepthLimit), cfg.MaxRecursionDepth)
+	}
+
+	if s, ok := ns.(namesys.DNSProofCacheSetter); ok {
+		s.SetDNSProofCache(proofCache)
+	}
+	if s, ok := ns.(namesys.DNSClientSubnetSetter); ok {
+		s.SetDNSClientSubnet(clientSubnet)
+	}
+	if s, ok := ns.(namesys.DNSLinkDepthLimitSetter); ok {
+		s.SetDNSLinkDepthLimit(uint(cfg.MaxRecursionDepth))
+	}
+	return nil
+}
+
+// dnsClientSubnet parses the DNS.ClientSubnet config setting. It returns nil
+// if no client-subnet should be sent.
+func dnsClientSubnet(subnet string) (*net.IPNet, error) {
+	if subnet == "" {
+		return nil, nil
+	}
+	_, ipnet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return nil, fmt.Errorf("failure to parse config setting DNS.ClientSubnet: %s", err)
 	}
+	return ipnet, nil
+}
+
+// dnsProofCache parses the DNS.ProofCache config section, filling in the
+// defaults for unset values.
+func dnsProofCache(cfg config.DNSProofCache) (namesys.DNSProofCacheConfig, error) {
+	if cfg.Disabled {
+		return namesys.DNSProofCacheConfig{Disabled: true}, nil
+	}
+
+	size := namesys.DefaultDNSProofCacheSize
+	if cfg.Size < 0 {
+		return namesys.DNSProofCacheConfig{}, fmt.Errorf("config setting DNS.ProofCache.Size is negative: %d", cfg.Size)
+	} else if cfg.Size > 0 {
+		size = cfg.Size
+	}
+
+	ttl := namesys.DefaultDNSProofCacheTTL
+	if cfg.TTL != "" {
+		d, err := time.ParseDuration(cfg.TTL)
+		if err != nil {
+			return namesys.DNSProofCacheConfig{}, fmt.Errorf("failure to parse config setting DNS.ProofCache.TTL: %s", err)
+		}
+		if d <= 0 {
+			return namesys.DNSProofCacheConfig{}, fmt.Errorf("config setting DNS.ProofCache.TTL is not positive: %s", d)
+		}
+		ttl = d
+	}
+
+	cleanup := namesys.DefaultDNSProofCacheCleanupInterval
+	if cfg.CleanupInterval != "" {
+		d, err := time.ParseDuration(cfg.CleanupInterval)
+		if err != nil {
+			return namesys.DNSProofCacheConfig{}, fmt.Errorf("failure to parse config setting DNS.ProofCache.CleanupInterval: %s", err)
+		}
+		if d <= 0 {
+			return namesys.DNSProofCacheConfig{}, fmt.Errorf("config setting DNS.ProofCache.CleanupInterval is not positive: %s", d)
+		}
+		cleanup = d
+	}
+
+	return namesys.DNSProofCacheConfig{
+		Size:            size,
+		TTL:             ttl,
+		CleanupInterval: cleanup,
+	}, nil
 }
 
 // IpnsRepublisher runs new IPNS republisher service
@@ -102,8 +102,13 @@ require (
 	github.com/whyrusleeping/multiaddr-filter v0.0.0-20160516205228-e903e4adabd7
 	github.com/whyrusleeping/tar-utils v0.0.0-20180509141711-8c6c8ba81d5c
 	go.uber.org/fx v1.10.0
+	golang.org/x/net v0.0.0-20190620200207-3b0461eec859
 	golang.org/x/sys v0.0.0-20200124204421-9fbb57f87de9
 	gopkg.in/cheggaaa/pb.v1 v1.0.28
 )
 
 go 1.12
+
+replace github.com/ipfs/go-bitswap => ./thirdparty/go-bitswap
+
+replace github.com/ipfs/go-ipld-cbor => ./thirdparty/go-ipld-cbor
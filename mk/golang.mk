@@ -25,6 +25,11 @@ TEST_GO :=
 TEST_GO_BUILD :=
 CHECK_GO :=
 
+# packages of the modules forked under thirdparty/ that carry local changes,
+# tested through the replace directives in go.mod
+GO_FORK_PKGS := github.com/ipfs/go-bitswap github.com/ipfs/go-bitswap/wantlist \
+	github.com/ipfs/go-bitswap/wantmanager github.com/ipfs/go-ipld-cbor
+
 go-pkg-name=$(shell $(GOCC) list $(go-tags) github.com/ipfs/go-ipfs/$(1))
 go-main-name=$(notdir $(call go-pkg-name,$(1)))$(?exe)
 go-curr-pkg-tgt=$(d)/$(call go-main-name,$(d))
@@ -46,7 +51,7 @@ $(GOCC) build $(go-flags-with-tags) -o /dev/null "$(call go-pkg-name,$<)"
 endef
 
 test_go_test: $$(DEPS_GO)
-	$(GOCC) test $(go-flags-with-tags) $(GOTFLAGS) ./...
+	$(GOCC) test $(go-flags-with-tags) $(GOTFLAGS) ./... $(GO_FORK_PKGS)
 .PHONY: test_go_test
 
 test_go_build: $$(TEST_GO_BUILD)
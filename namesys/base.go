@@ -24,37 +24,44 @@ type resolver interface {
 
 // resolve is a helper for implementing Resolver.ResolveN using resolveOnce.
 func resolve(ctx context.Context, r resolver, name string, options opts.ResolveOpts) (path.Path, error) {
+	res := resolveFinal(ctx, r, name, options)
+	return res.Path, res.Err
+}
+
+// resolveFinal resolves name and returns the final result, passing its cache
+// tag, TTL and proof on to the context.
+func resolveFinal(ctx context.Context, r resolver, name string, options opts.ResolveOpts) Result {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	var (
-		p        path.Path
-		cacheTag *string
-		proof    [][]byte
-		err      = ErrResolveFailed
-	)
+	final := Result{Err: ErrResolveFailed}
 
 	resCh := resolveAsync(ctx, r, name, options)
 
 	for res := range resCh {
-		p, cacheTag, proof, err = res.Path, res.CacheTag, res.Proof, res.Err
-		if err != nil {
+		final = res
+		if res.Err != nil {
 			break
 		}
 	}
 
-	if cacheTag != nil {
+	if final.CacheTag != nil {
 		if ct, ok := ctx.Value("cache-tag").(*string); ok {
-			*ct = *cacheTag
+			*ct = *final.CacheTag
+		}
+	}
+	if final.TTL > 0 {
+		if ttl, ok := ctx.Value("cache-ttl").(*time.Duration); ok {
+			*ttl = final.TTL
 		}
 	}
 	if pw, ok := ctx.Value("proxy-preamble").(coreiface.ProofWriter); ok {
-		for _, p := range proof {
+		for _, p := range final.Proof {
 			pw.WriteChunk(p)
 		}
 	}
 
-	return p, err
+	return final
 }
 
 func resolveAsync(ctx context.Context, r resolver, name string, options opts.ResolveOpts) <-chan Result {
@@ -69,6 +76,8 @@ func resolveAsync(ctx context.Context, r resolver, name string, options opts.Res
 		var cancelSub context.CancelFunc
 		var cacheTag *string
 		var proofStub [][]byte
+		var hopStub []path.Path
+		var ttlStub time.Duration
 		defer func() {
 			if cancelSub != nil {
 				cancelSub()
@@ -89,7 +98,7 @@ func resolveAsync(ctx context.Context, r resolver, name string, options opts.Res
 				}
 				log.Debugf("resolved %s to %s", name, res.value.String())
 				if !strings.HasPrefix(res.value.String(), ipnsPrefix) {
-					emitResult(ctx, outCh, Result{Path: res.value, CacheTag: res.cacheTag, Proof: res.proof})
+					emitResult(ctx, outCh, Result{Path: res.value, CacheTag: res.cacheTag, Proof: res.proof, TTL: res.ttl})
 					break
 				}
 
@@ -117,6 +126,8 @@ func resolveAsync(ctx context.Context, r resolver, name string, options opts.Res
 					cacheTag = res.cacheTag
 				}
 				proofStub = res.proof
+				hopStub = []path.Path{res.value}
+				ttlStub = res.ttl
 			case res, ok := <-subCh:
 				if !ok {
 					subCh = nil
@@ -129,6 +140,8 @@ func resolveAsync(ctx context.Context, r resolver, name string, options opts.Res
 					res.CacheTag = cacheTag
 				}
 				res.Proof = append(proofStub, res.Proof...)
+				res.Hops = append(hopStub, res.Hops...)
+				res.TTL = minTTL(ttlStub, res.TTL)
 				emitResult(ctx, outCh, res)
 			case <-ctx.Done():
 				return
@@ -141,6 +154,14 @@ func resolveAsync(ctx context.Context, r resolver, name string, options opts.Res
 	return outCh
 }
 
+// minTTL returns the smaller of two TTLs, where zero means unknown.
+func minTTL(a, b time.Duration) time.Duration {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
 func emitResult(ctx context.Context, outCh chan<- Result, r Result) {
 	select {
 	case outCh <- r:
@@ -1,19 +1,36 @@
 package namesys
 
 import (
+	"context"
 	"time"
 
 	path "github.com/ipfs/go-path"
 )
 
-func (ns *mpns) cacheGet(name string) (path.Path, *string, [][]byte, bool) {
+// WithoutCache returns a context under which resolutions ignore cached
+// results, including cached DNSSEC-validated responses, and go to the
+// network. Fresh results are still cached.
+func WithoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, "nocache", true)
+}
+
+// bypassCache reports whether ctx asks for cached results to be ignored.
+func bypassCache(ctx context.Context) bool {
+	nocache, _ := ctx.Value("nocache").(bool)
+	return nocache
+}
+
+// cacheGet returns the cached resolution of name. The returned TTL is what's
+// left of the one it was resolved with, so that callers passing it on don't
+// extend the lifetime of the result.
+func (ns *mpns) cacheGet(name string) (path.Path, *string, [][]byte, time.Duration, bool) {
 	if ns.cache == nil {
-		return "", nil, nil, false
+		return "", nil, nil, 0, false
 	}
 
 	ientry, ok := ns.cache.Get(name)
 	if !ok {
-		return "", nil, nil, false
+		return "", nil, nil, 0, false
 	}
 
 	entry, ok := ientry.(cacheEntry)
@@ -23,23 +40,27 @@ func (ns *mpns) cacheGet(name string) (path.Path, *string, [][]byte, bool) {
 	}
 
 	if time.Now().Before(entry.eol) {
-		return entry.val, entry.cacheTag, entry.proof, true
+		return entry.val, entry.cacheTag, entry.proof, time.Until(entry.ttlEol), true
 	}
 
 	ns.cache.Remove(name)
 
-	return "", nil, nil, false
+	return "", nil, nil, 0, false
 }
 
-func (ns *mpns) cacheSet(name string, val path.Path, cacheTag *string, proof [][]byte, ttl time.Duration) {
-	if ns.cache == nil || ttl <= 0 {
+// cacheSet caches the resolution of name for lifetime, which must not be more
+// than its TTL.
+func (ns *mpns) cacheSet(name string, val path.Path, cacheTag *string, proof [][]byte, ttl, lifetime time.Duration) {
+	if ns.cache == nil || lifetime <= 0 {
 		return
 	}
+	now := time.Now()
 	ns.cache.Add(name, cacheEntry{
 		val:      val,
 		cacheTag: cacheTag,
 		proof:    proof,
-		eol:      time.Now().Add(ttl),
+		eol:      now.Add(lifetime),
+		ttlEol:   now.Add(ttl),
 	})
 }
 
@@ -47,5 +68,6 @@ type cacheEntry struct {
 	val      path.Path
 	cacheTag *string
 	proof    [][]byte
-	eol      time.Time
+	eol      time.Time // when the entry is evicted
+	ttlEol   time.Time // when the TTL it was resolved with runs out
 }
@@ -9,6 +9,7 @@ import (
 	"net"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ipfs/go-ipfs/namesys/dnssec"
@@ -16,31 +17,115 @@ import (
 	path "github.com/ipfs/go-path"
 	opts "github.com/ipfs/interface-go-ipfs-core/options/namesys"
 	isd "github.com/jbenet/go-is-domain"
+	"golang.org/x/net/idna"
 )
 
 const ethTLD = "eth"
 const linkTLD = "link"
 
-type LookupTXTFunc func(name string) (txt []string, err error)
+// Defaults for the cache of DNSSEC-validated responses.
+const (
+	DefaultDNSProofCacheSize            = 4096
+	DefaultDNSProofCacheTTL             = 10 * time.Second
+	DefaultDNSProofCacheCleanupInterval = 5 * time.Second
+)
+
+// dnslinkGracePeriod is how long an answer for the bare domain is held back
+// in case the _dnslink subdomain, which takes precedence, answers too.
+var dnslinkGracePeriod = 50 * time.Millisecond
+
+// idnaProfile maps internationalized domain names to the ASCII form used on
+// the wire, the way browsers do.
+var idnaProfile = idna.New(idna.MapForLookup(), idna.Transitional(false), idna.BidiRule())
+
+// normalizeDomain converts an internationalized domain name to its ASCII
+// (punycode) form, so that both spellings resolve and cache alike. Names that
+// can't be converted are returned unchanged.
+func normalizeDomain(domain string) string {
+	ascii, err := idnaProfile.ToASCII(domain)
+	if err != nil {
+		return domain
+	}
+	return ascii
+}
+
+type LookupTXTFunc func(ctx context.Context, name string) (txt []string, err error)
 
 // DNSResolver implements a Resolver on DNS domains
 type DNSResolver struct {
 	lookupTXT LookupTXTFunc
 	// TODO: maybe some sort of caching?
 	// cache would need a timeout
+
+	dnssecLk       sync.RWMutex
 	dnssecResolver *dnssec.Resolver
+	proofCacheCfg  DNSProofCacheConfig
+}
+
+// DNSProofCacheConfig describes the cache of DNSSEC-validated responses.
+type DNSProofCacheConfig struct {
+	Disabled        bool
+	Size            int
+	TTL             time.Duration
+	CleanupInterval time.Duration
+}
+
+// DefaultDNSProofCacheConfig is the proof cache a new DNSResolver starts with.
+var DefaultDNSProofCacheConfig = DNSProofCacheConfig{
+	Size:            DefaultDNSProofCacheSize,
+	TTL:             DefaultDNSProofCacheTTL,
+	CleanupInterval: DefaultDNSProofCacheCleanupInterval,
+}
+
+func (cfg DNSProofCacheConfig) newCache() *dnscache.Cache {
+	if cfg.Disabled {
+		return nil
+	}
+	return dnscache.New(cfg.TTL, cfg.CleanupInterval, cfg.Size)
 }
 
 // NewDNSResolver constructs a name resolver using DNS TXT records.
 func NewDNSResolver() *DNSResolver {
 	return &DNSResolver{
-		lookupTXT: net.LookupTXT,
+		lookupTXT: net.DefaultResolver.LookupTXT,
 		dnssecResolver: &dnssec.Resolver{
-			Cache: dnscache.New(10*time.Second, 5*time.Second, 4096),
+			Cache: DefaultDNSProofCacheConfig.newCache(),
 		},
+		proofCacheCfg: DefaultDNSProofCacheConfig,
 	}
 }
 
+// SetProofCache replaces the cache of DNSSEC-validated responses with one
+// built from cfg, unless cfg is unchanged, in which case the current cache and
+// its contents are kept. Lookups already in progress keep using the old cache.
+func (r *DNSResolver) SetProofCache(cfg DNSProofCacheConfig) {
+	r.dnssecLk.Lock()
+	defer r.dnssecLk.Unlock()
+	if cfg == r.proofCacheCfg {
+		return
+	}
+	sr := *r.dnssecResolver
+	sr.Cache = cfg.newCache()
+	r.dnssecResolver = &sr
+	r.proofCacheCfg = cfg
+}
+
+// SetClientSubnet sets the EDNS client-subnet sent on DNSSEC TXT lookups. A
+// nil subnet sends none.
+func (r *DNSResolver) SetClientSubnet(subnet *net.IPNet) {
+	r.dnssecLk.Lock()
+	defer r.dnssecLk.Unlock()
+	sr := *r.dnssecResolver
+	sr.ClientSubnet = subnet
+	r.dnssecResolver = &sr
+}
+
+func (r *DNSResolver) secureResolver() *dnssec.Resolver {
+	r.dnssecLk.RLock()
+	defer r.dnssecLk.RUnlock()
+	return r.dnssecResolver
+}
+
 // Resolve implements Resolver.
 func (r *DNSResolver) Resolve(ctx context.Context, name string, options ...opts.ResolveOpt) (path.Path, error) {
 	return resolve(ctx, r, name, opts.ProcessOpts(options))
@@ -55,6 +140,7 @@ type lookupRes struct {
 	path     path.Path
 	cacheTag *string
 	proof    [][]byte
+	ttl      time.Duration
 	error    error
 }
 
@@ -65,7 +151,7 @@ func (r *DNSResolver) resolveOnceAsync(ctx context.Context, name string, needsPr
 	var fqdn string
 	out := make(chan onceResult, 1)
 	segments := strings.SplitN(name, "/", 2)
-	domain := segments[0]
+	domain := normalizeDomain(segments[0])
 
 	if !isd.IsDomain(domain) {
 		out <- onceResult{err: errors.New("not a valid domain name")}
@@ -86,11 +172,13 @@ func (r *DNSResolver) resolveOnceAsync(ctx context.Context, name string, needsPr
 		fqdn += linkTLD + "."
 	}
 
+	rootCtx, cancelRoot := context.WithCancel(ctx)
 	rootChan := make(chan lookupRes, 1)
-	go workDomain(ctx, r, fqdn, needsProof, rootChan)
+	go workDomain(rootCtx, r, fqdn, needsProof, rootChan)
 
+	subCtx, cancelSub := context.WithCancel(ctx)
 	subChan := make(chan lookupRes, 1)
-	go workDomain(ctx, r, "_dnslink."+fqdn, needsProof, subChan)
+	go workDomain(subCtx, r, "_dnslink."+fqdn, needsProof, subChan)
 
 	appendPath := func(p path.Path) (path.Path, error) {
 		if len(segments) > 1 {
@@ -99,8 +187,21 @@ func (r *DNSResolver) resolveOnceAsync(ctx context.Context, name string, needsPr
 		return p, nil
 	}
 
+	emit := func(res lookupRes) {
+		p, err := appendPath(res.path)
+		emitOnceResult(ctx, out, onceResult{value: p, cacheTag: res.cacheTag, proof: res.proof, ttl: res.ttl, err: err})
+	}
+
 	go func() {
 		defer close(out)
+		defer cancelRoot()
+		defer cancelSub()
+
+		// An answer for _dnslink wins as soon as it arrives, and the root
+		// lookup is abandoned. An answer for the root is held for a grace
+		// period, after which the _dnslink lookup is abandoned instead.
+		var rootRes *lookupRes
+		var grace <-chan time.Time
 		for {
 			select {
 			case subRes, ok := <-subChan:
@@ -109,24 +210,36 @@ func (r *DNSResolver) resolveOnceAsync(ctx context.Context, name string, needsPr
 					break
 				}
 				if subRes.error == nil {
-					p, err := appendPath(subRes.path)
-					emitOnceResult(ctx, out, onceResult{value: p, cacheTag: subRes.cacheTag, proof: subRes.proof, err: err})
+					cancelRoot()
+					emit(subRes)
 					return
 				}
-			case rootRes, ok := <-rootChan:
+			case res, ok := <-rootChan:
 				if !ok {
 					rootChan = nil
 					break
 				}
-				if rootRes.error == nil {
-					p, err := appendPath(rootRes.path)
-					emitOnceResult(ctx, out, onceResult{value: p, cacheTag: rootRes.cacheTag, proof: rootRes.proof, err: err})
+				if res.error == nil {
+					rootRes = &res
+					t := time.NewTimer(dnslinkGracePeriod)
+					defer t.Stop()
+					grace = t.C
 				}
+			case <-grace:
+				cancelSub()
+				emit(*rootRes)
+				return
 			case <-ctx.Done():
 				return
 			}
-			if subChan == nil && rootChan == nil {
-				return
+			if subChan == nil {
+				if rootRes != nil {
+					emit(*rootRes)
+					return
+				}
+				if rootChan == nil {
+					return
+				}
 			}
 		}
 	}()
@@ -142,36 +255,53 @@ func workDomain(ctx context.Context, r *DNSResolver, name string, needsProof boo
 		proof *dnssec.Result
 		err   error
 	)
+	start := time.Now()
 	if needsProof {
-		txt, proof, err = r.dnssecResolver.LookupTXT(ctx, name)
+		sr := r.secureResolver()
+		if bypassCache(ctx) {
+			uncached := *sr
+			uncached.Cache = nil
+			sr = &uncached
+		}
+		txt, proof, err = sr.LookupTXT(ctx, name)
+		RecordTiming(ctx, TimingDNSSEC, name, start)
+		if ce, ok := err.(*dnssec.ChainError); ok {
+			if rep, ok := ctx.Value("dnssec-report").(*dnssec.Report); ok {
+				rep.Add(ce)
+			}
+		}
 	} else {
-		txt, err = r.lookupTXT(name)
+		txt, err = r.lookupTXT(ctx, name)
+		RecordTiming(ctx, TimingDNS, name, start)
 	}
 	if err != nil {
-		res <- lookupRes{"", nil, nil, err}
+		res <- lookupRes{error: err}
 		return
 	}
 
-	// Serialize proof, it one was computed
+	// Serialize proof, it one was computed. Only validated answers come
+	// with a TTL; plain lookups leave it unknown.
 	var rawProof []byte
+	var ttl time.Duration
 	if proof != nil {
 		rawProof, err = proof.MarshalBinary()
 		if err != nil {
-			res <- lookupRes{"", nil, nil, err}
+			res <- lookupRes{error: err}
 			return
 		}
 		rawProof = append([]byte{0}, rawProof...)
+		ttl = proof.TTL()
 	}
 
 	// Return first valid record
 	for _, t := range txt {
 		p, err := parseEntry(t)
 		if err == nil {
-			res <- lookupRes{p, dnsCacheTag(txt), [][]byte{rawProof}, nil}
+			res <- lookupRes{path: p, cacheTag: dnsCacheTag(txt), proof: [][]byte{rawProof}, ttl: ttl}
 			return
 		}
 	}
-	res <- lookupRes{"", nil, nil, ErrResolveFailed}
+	res <- lookupRes{error: ErrResolveFailed}
 }
 
 func parseEntry(txt string) (path.Path, error) {
@@ -1,8 +1,10 @@
 package namesys
 
 import (
+	"context"
 	"fmt"
 	"testing"
+	"time"
 
 	opts "github.com/ipfs/interface-go-ipfs-core/options/namesys"
 )
@@ -11,7 +13,7 @@ type mockDNS struct {
 	entries map[string][]string
 }
 
-func (m *mockDNS) lookupTXT(name string) (txt []string, err error) {
+func (m *mockDNS) lookupTXT(ctx context.Context, name string) (txt []string, err error) {
 	txt, ok := m.entries[name]
 	if !ok {
 		return nil, fmt.Errorf("no TXT entry for %s", name)
@@ -170,3 +172,92 @@ func TestDNSResolution(t *testing.T) {
 	testResolution(t, r, "www.wealdtech.eth", 2, "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD", nil)
 	testResolution(t, r, "www.wealdtech.eth.link", 2, "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD", nil)
 }
+
+func TestDNSLinkPreferredWithinGracePeriod(t *testing.T) {
+	r := &DNSResolver{lookupTXT: func(ctx context.Context, name string) ([]string, error) {
+		if name == "_dnslink.example.com." {
+			time.Sleep(dnslinkGracePeriod / 5)
+			return []string{"dnslink=/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjE"}, nil
+		}
+		return []string{"dnslink=/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD"}, nil
+	}}
+	testResolution(t, r, "example.com", opts.DefaultDepthLimit, "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjE", nil)
+}
+
+func TestDNSLinkCancelledAfterGracePeriod(t *testing.T) {
+	cancelled := make(chan struct{})
+	r := &DNSResolver{lookupTXT: func(ctx context.Context, name string) ([]string, error) {
+		if name == "_dnslink.example.com." {
+			<-ctx.Done()
+			close(cancelled)
+			return nil, ctx.Err()
+		}
+		return []string{"dnslink=/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD"}, nil
+	}}
+	testResolution(t, r, "example.com", opts.DefaultDepthLimit, "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD", nil)
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("_dnslink lookup wasn't cancelled")
+	}
+}
+
+func TestDNSResolutionIDN(t *testing.T) {
+	mock := &mockDNS{
+		entries: map[string][]string{
+			"_dnslink.xn--80aswg.xn--p1ai.": []string{
+				"dnslink=/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD",
+			},
+		},
+	}
+	r := &DNSResolver{lookupTXT: mock.lookupTXT}
+	testResolution(t, r, "сайт.рф", opts.DefaultDepthLimit, "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD", nil)
+	testResolution(t, r, "xn--80aswg.xn--p1ai", opts.DefaultDepthLimit, "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD", nil)
+}
+
+func TestDNSResolutionTimings(t *testing.T) {
+	mock := &mockDNS{
+		entries: map[string][]string{
+			"_dnslink.example.com.": []string{
+				"dnslink=/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD",
+			},
+		},
+	}
+	r := &DNSResolver{lookupTXT: mock.lookupTXT}
+
+	timings := new(Timings)
+	ctx := context.WithValue(context.Background(), "resolve-timings", timings)
+	if _, err := r.Resolve(ctx, "example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tm := range timings.Timings() {
+		if tm.Stage == TimingDNS && tm.Name == "_dnslink.example.com." {
+			return
+		}
+	}
+	t.Fatalf("no timing for _dnslink.example.com. in %v", timings.Timings())
+}
+
+func TestDNSResolverSetProofCache(t *testing.T) {
+	r := NewDNSResolver()
+	c := r.secureResolver().Cache
+
+	r.SetProofCache(DefaultDNSProofCacheConfig)
+	if r.secureResolver().Cache != c {
+		t.Fatal("unchanged settings rebuilt the proof cache")
+	}
+
+	cfg := DefaultDNSProofCacheConfig
+	cfg.TTL = time.Minute
+	r.SetProofCache(cfg)
+	if r.secureResolver().Cache == c || r.secureResolver().Cache == nil {
+		t.Fatal("changed settings didn't rebuild the proof cache")
+	}
+
+	r.SetProofCache(DNSProofCacheConfig{Disabled: true})
+	if r.secureResolver().Cache != nil {
+		t.Fatal("disabled proof cache is still set")
+	}
+}
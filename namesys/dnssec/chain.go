@@ -0,0 +1,121 @@
+package dnssec
+
+import (
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// ChainLink is one step taken while building a chain-of-trust: the records of
+// type Type were fetched for Zone and, at the end, checked against the keys of
+// the zone above.
+type ChainLink struct {
+	Zone string
+	Type string
+
+	// Err is why this link failed, or empty if it didn't.
+	Err string `json:",omitempty"`
+}
+
+// ChainError is returned when a response can't be authenticated. Chain lists
+// every link that was tried, in order, so that a partially signed zone or an
+// expired signature can be pinned down.
+type ChainError struct {
+	Name  string
+	Chain []ChainLink
+
+	// Message describes the error that ended the lookup. Unlike the error
+	// itself, it survives being sent to a remote client.
+	Message string
+
+	err error
+}
+
+func newChainError(name string, chain []ChainLink, err error) *ChainError {
+	return &ChainError{Name: name, Chain: chain, Message: err.Error(), err: err}
+}
+
+func (e *ChainError) Error() string {
+	if e == nil {
+		return "<nil>"
+	}
+	if e.err != nil {
+		return e.err.Error()
+	}
+	return e.Message
+}
+
+// Unwrap returns the error that ended the lookup. It's nil for a ChainError
+// decoded from JSON.
+func (e *ChainError) Unwrap() error {
+	if e == nil {
+		return nil
+	}
+	return e.err
+}
+
+// Failed returns the links that failed.
+func (e *ChainError) Failed() []ChainLink {
+	var out []ChainLink
+	for _, l := range e.Chain {
+		if l.Err != "" {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// Report collects the ChainErrors seen while resolving a name. Resolvers add
+// to a Report found in the context under "dnssec-report".
+type Report struct {
+	lk     sync.Mutex
+	errors []*ChainError
+}
+
+// Add records a failed lookup.
+func (r *Report) Add(e *ChainError) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	r.errors = append(r.errors, e)
+}
+
+// Errors returns the failed lookups recorded so far.
+func (r *Report) Errors() []*ChainError {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	return append([]*ChainError(nil), r.errors...)
+}
+
+// linkError attributes a verification failure to the records it happened on.
+type linkError struct {
+	msg *dns.Msg
+	err error
+}
+
+func (e *linkError) Error() string {
+	return e.err.Error()
+}
+
+// wrapLink annotates err, if any, with the records in msg.
+func wrapLink(msg *dns.Msg, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &linkError{msg, err}
+}
+
+func newLink(name string, qtype uint16, err error) ChainLink {
+	l := ChainLink{Zone: name, Type: dns.TypeToString[qtype]}
+	if err != nil {
+		l.Err = err.Error()
+	}
+	return l
+}
+
+func msgLink(msg *dns.Msg, err error) ChainLink {
+	if msg == nil || len(msg.Question) == 0 {
+		return newLink("", 0, err)
+	}
+	q := msg.Question[0]
+	return newLink(q.Name, q.Qtype, err)
+}
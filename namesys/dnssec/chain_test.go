@@ -0,0 +1,68 @@
+package dnssec
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestNewResultAttributesFailure(t *testing.T) {
+	keyMsg := new(dns.Msg)
+	keyMsg.SetQuestion("example.com.", dns.TypeDNSKEY)
+	resMsg := new(dns.Msg)
+	resMsg.SetQuestion("example.com.", dns.TypeTXT)
+
+	_, err := newResult(nil, keyMsg, resMsg)
+	le, ok := err.(*linkError)
+	if !ok {
+		t.Fatalf("expected a linkError, got %T: %v", err, err)
+	}
+
+	link := msgLink(le.msg, le.err)
+	if link.Zone != "example.com." || link.Type != "DNSKEY" || link.Err == "" {
+		t.Fatalf("unexpected link: %+v", link)
+	}
+}
+
+func TestChainErrorFailed(t *testing.T) {
+	ce := &ChainError{
+		Name: "_dnslink.example.com.",
+		Chain: []ChainLink{
+			{Zone: "_dnslink.example.com.", Type: "TXT"},
+			{Zone: "example.com.", Type: "DNSKEY"},
+			{Zone: "example.com.", Type: "DS", Err: "response is not signed (Is DNSSEC configured?)"},
+		},
+	}
+
+	failed := ce.Failed()
+	if len(failed) != 1 || failed[0].Type != "DS" {
+		t.Fatalf("unexpected failed links: %+v", failed)
+	}
+}
+
+func TestChainErrorJSON(t *testing.T) {
+	ce := newChainError("_dnslink.example.com.", []ChainLink{
+		{Zone: "example.com.", Type: "DS", Err: "response is not signed"},
+	}, errors.New("response is not signed"))
+
+	data, err := json.Marshal(ce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded ChainError
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Error() != ce.Error() {
+		t.Fatalf("expected %q after decoding, got %q", ce.Error(), decoded.Error())
+	}
+	if decoded.Unwrap() != nil {
+		t.Fatalf("expected no wrapped error after decoding, got %v", decoded.Unwrap())
+	}
+	if len(decoded.Chain) != 1 || decoded.Chain[0].Type != "DS" {
+		t.Fatalf("unexpected chain after decoding: %+v", decoded.Chain)
+	}
+}
@@ -50,6 +50,11 @@ type cacheEntry struct {
 
 type Resolver struct {
 	Cache *cache.Cache
+
+	// ClientSubnet, if set, is sent as the EDNS client-subnet option on TXT
+	// queries. A zero-length prefix (0.0.0.0/0) asks the upstream resolver not
+	// to use any client-derived locality. If nil, no option is sent.
+	ClientSubnet *net.IPNet
 }
 
 func (r *Resolver) LookupA(ctx context.Context, name string) ([]string, *Result, error) {
@@ -98,8 +103,9 @@ func (r *Resolver) lookup(ctx context.Context, name string, qtype uint16) (*Resu
 	defer conn.Close()
 
 	q := &query{
-		cache: r.Cache,
-		conn:  conn,
+		cache:  r.Cache,
+		conn:   conn,
+		subnet: r.ClientSubnet,
 	}
 	return q.lookup(name, qtype)
 }
@@ -130,15 +136,27 @@ func (r *Resolver) connect(ctx context.Context) (*dns.Conn, error) {
 }
 
 type query struct {
-	cache *cache.Cache
-	conn  *dns.Conn
+	cache  *cache.Cache
+	conn   *dns.Conn
+	subnet *net.IPNet
 
 	steps int
 	keys  *dns.Msg
 	res   *dns.Msg
+
+	// chain records every link tried, for reporting failures.
+	chain []ChainLink
 }
 
 func (q *query) lookup(name string, qtype uint16) (*Result, error) {
+	res, err := q.lookupChain(name, qtype)
+	if err != nil {
+		return nil, newChainError(name, q.chain, err)
+	}
+	return res, nil
+}
+
+func (q *query) lookupChain(name string, qtype uint16) (*Result, error) {
 	// Get the data the client asked for.
 	res, signers, err := q.exchangeOneC(name, qtype)
 	if err != nil {
@@ -149,8 +167,10 @@ func (q *query) lookup(name string, qtype uint16) (*Result, error) {
 
 	// Foreach candidate signer, fetch their keyset and try to build a
 	// chain-of-trust to the root zone that authenticates the response.
+	err = fmt.Errorf("response has no signers")
 	for _, signer := range signers {
-		keys, _, err := q.exchangeOneC(signer, dns.TypeDNSKEY)
+		var keys *dns.Msg
+		keys, _, err = q.exchangeOneC(signer, dns.TypeDNSKEY)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get signer's keyset: %v", err)
 		}
@@ -173,11 +193,17 @@ func (q *query) lookup(name string, qtype uint16) (*Result, error) {
 // return the first chain that validates.
 func (q *query) authenticate(signer string, delegs []delegMsg) (*Result, error) {
 	if signer == "." {
-		return newResult(reverseDelegs(delegs), q.keys, q.res)
+		res, err := newResult(reverseDelegs(delegs), q.keys, q.res)
+		if le, ok := err.(*linkError); ok {
+			q.chain = append(q.chain, msgLink(le.msg, le.err))
+		}
+		return res, err
 	}
 	const maxSteps = 10
 	if q.steps >= maxSteps {
-		return nil, fmt.Errorf("tracing the chain of authority took too long")
+		err := fmt.Errorf("tracing the chain of authority took too long")
+		q.chain = append(q.chain, newLink(signer, dns.TypeDS, err))
+		return nil, err
 	}
 	q.steps += 1
 
@@ -186,8 +212,10 @@ func (q *query) authenticate(signer string, delegs []delegMsg) (*Result, error)
 		return nil, fmt.Errorf("failed to find delegation: %v", err)
 	}
 
+	err = fmt.Errorf("delegation has no authorities")
 	for _, auth := range authorities {
-		authKeys, _, err := q.exchangeOneC(auth, dns.TypeDNSKEY)
+		var authKeys *dns.Msg
+		authKeys, _, err = q.exchangeOneC(auth, dns.TypeDNSKEY)
 		if err != nil {
 			err = fmt.Errorf("failed to get authority's keyset: %v", err)
 			continue
@@ -206,12 +234,19 @@ func (q *query) authenticate(signer string, delegs []delegMsg) (*Result, error)
 	return nil, err
 }
 
-// exchangeOneC is a caching wrapper around exchangeOne.
+// exchangeOneC is a caching wrapper around exchangeOne. It also records the
+// exchange as a link in the chain.
 func (q *query) exchangeOneC(name string, qtype uint16) (*dns.Msg, []string, error) {
+	msg, signers, err := q.exchangeOneCached(name, qtype)
+	q.chain = append(q.chain, newLink(name, qtype, err))
+	return msg, signers, err
+}
+
+func (q *query) exchangeOneCached(name string, qtype uint16) (*dns.Msg, []string, error) {
 	if q.cache == nil {
 		return q.exchangeOne(name, qtype)
 	}
-	cacheKey := fmt.Sprintf("%v:%v", name, qtype)
+	cacheKey := q.cacheKey(name, qtype)
 
 	res, ok := q.cache.Get(cacheKey)
 	if ok {
@@ -228,6 +263,15 @@ func (q *query) exchangeOneC(name string, qtype uint16) (*dns.Msg, []string, err
 	return msg.Copy(), copySlice(signers), nil
 }
 
+// cacheKey returns the cache key for a question. Answers to TXT questions
+// depend on the client-subnet sent with them, so it's part of their key.
+func (q *query) cacheKey(name string, qtype uint16) string {
+	if qtype == dns.TypeTXT && q.subnet != nil {
+		return fmt.Sprintf("%v:%v:%v", name, qtype, q.subnet)
+	}
+	return fmt.Sprintf("%v:%v", name, qtype)
+}
+
 // exchangeOne sends a question to the resolver at `conn` and reads the
 // response. It checks that the response is well-formed and signed (the
 // signature is not verified). It returns the resolver's response and the
@@ -236,6 +280,10 @@ func (q *query) exchangeOne(name string, qtype uint16) (*dns.Msg, []string, erro
 	req := new(dns.Msg)
 	req.SetQuestion(name, qtype)
 	req.SetEdns0(4096, true) // Tell the nameserver we support DNSSEC.
+	if qtype == dns.TypeTXT && q.subnet != nil {
+		opt := req.IsEdns0()
+		opt.Option = append(opt.Option, clientSubnet(q.subnet))
+	}
 
 	err := q.conn.WriteMsg(req)
 	if err != nil {
@@ -289,6 +337,23 @@ func (q *query) exchangeOne(name string, qtype uint16) (*dns.Msg, []string, erro
 	return res, signers, nil
 }
 
+// clientSubnet builds the EDNS client-subnet option for subnet.
+func clientSubnet(subnet *net.IPNet) *dns.EDNS0_SUBNET {
+	ones, _ := subnet.Mask.Size()
+	e := &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		SourceNetmask: uint8(ones),
+	}
+	if ip4 := subnet.IP.To4(); ip4 != nil {
+		e.Family = 1
+		e.Address = ip4
+	} else {
+		e.Family = 2
+		e.Address = subnet.IP
+	}
+	return e
+}
+
 func reverseDelegs(in []delegMsg) []delegMsg {
 	if in == nil {
 		return nil
@@ -3,6 +3,13 @@ package dnssec
 import (
 	"context"
 	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-ipfs/namesys/dnssec/cache"
+	"github.com/miekg/dns"
 )
 
 func ExampleResolver_LookupTXT() {
@@ -23,3 +30,79 @@ func ExampleResolver_LookupTXT() {
 	// [secure txt record] <nil>
 	// [] unexpected record name: dnssec.brendans.website.
 }
+
+func TestClientSubnet(t *testing.T) {
+	for _, tc := range []struct {
+		cidr   string
+		family uint16
+		mask   uint8
+	}{
+		{"203.0.113.0/24", 1, 24},
+		{"0.0.0.0/0", 1, 0},
+		{"2001:db8::/56", 2, 56},
+	} {
+		_, subnet, err := net.ParseCIDR(tc.cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := clientSubnet(subnet)
+		if e.Family != tc.family || e.SourceNetmask != tc.mask || !e.Address.Equal(subnet.IP) {
+			t.Errorf("%s: unexpected option %+v", tc.cidr, e)
+		}
+	}
+}
+
+func TestCacheKeySubnet(t *testing.T) {
+	_, a, _ := net.ParseCIDR("203.0.113.0/24")
+	_, b, _ := net.ParseCIDR("198.51.100.0/24")
+	qa, qb, qnone := &query{subnet: a}, &query{subnet: b}, &query{}
+
+	if qa.cacheKey("example.com.", dns.TypeTXT) == qb.cacheKey("example.com.", dns.TypeTXT) {
+		t.Error("TXT answers for different subnets share a cache key")
+	}
+	if qa.cacheKey("example.com.", dns.TypeTXT) == qnone.cacheKey("example.com.", dns.TypeTXT) {
+		t.Error("TXT answers with and without a subnet share a cache key")
+	}
+	if qa.cacheKey("example.com.", dns.TypeDNSKEY) != qb.cacheKey("example.com.", dns.TypeDNSKEY) {
+		t.Error("DNSKEY answers, sent without a subnet, have different cache keys")
+	}
+}
+
+func TestLookupAuthorityKeysetFailure(t *testing.T) {
+	client, server := net.Pipe()
+	server.Close()
+	defer client.Close()
+
+	q := &query{cache: cache.New(time.Minute, 0, 16), conn: &dns.Conn{Conn: client}}
+	for _, e := range []struct {
+		name    string
+		qtype   uint16
+		signers []string
+	}{
+		{"_dnslink.example.com.", dns.TypeTXT, []string{"example.com."}},
+		{"example.com.", dns.TypeDNSKEY, []string{"example.com."}},
+		{"example.com.", dns.TypeDS, []string{"com."}},
+	} {
+		msg := new(dns.Msg)
+		msg.SetQuestion(e.name, e.qtype)
+		q.cache.Set(q.cacheKey(e.name, e.qtype), cacheEntry{msg, e.signers}, cache.DefaultExpiration)
+	}
+
+	// The DNSKEY query for com. isn't cached and fails on the closed
+	// connection.
+	res, err := q.lookup("_dnslink.example.com.", dns.TypeTXT)
+	if res != nil {
+		t.Fatalf("expected no result, got %+v", res)
+	}
+	ce, ok := err.(*ChainError)
+	if !ok {
+		t.Fatalf("expected a ChainError, got %T: %v", err, err)
+	}
+	if !strings.Contains(ce.Error(), "authority's keyset") {
+		t.Fatalf("unexpected error: %v", ce)
+	}
+	failed := ce.Failed()
+	if len(failed) != 1 || failed[0].Zone != "com." || failed[0].Type != "DNSKEY" {
+		t.Fatalf("unexpected failed links: %+v", failed)
+	}
+}
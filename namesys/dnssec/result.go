@@ -3,6 +3,7 @@ package dnssec
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/miekg/dns"
@@ -48,11 +49,11 @@ func newResult(delegMsgs []delegMsg, keyMsg, resMsg *dns.Msg) (*Result, error) {
 
 	keys, keySig, err := chooseKeyset(digests, keyMsg)
 	if err != nil {
-		return nil, err
+		return nil, wrapLink(keyMsg, err)
 	}
 	data, dataSig, err := chooseRecs(keys, resMsg)
 	if err != nil {
-		return nil, err
+		return nil, wrapLink(resMsg, err)
 	}
 
 	return &Result{
@@ -117,6 +118,17 @@ func (r *Result) TXT(name string) ([]string, error) {
 	return out, nil
 }
 
+// TTL returns the smallest TTL of the records answering the query.
+func (r *Result) TTL() time.Duration {
+	var ttl uint32
+	for i, rr := range r.Data {
+		if i == 0 || rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+		}
+	}
+	return time.Duration(ttl) * time.Second
+}
+
 func (r *Result) Verify() error {
 	digests := rootDigests
 	for _, deleg := range r.Delegations {
@@ -198,11 +210,11 @@ type Delegation struct {
 func newDelegation(digests []*dns.DS, msgs delegMsg) (*Delegation, error) {
 	keys, keySig, err := chooseKeyset(digests, msgs.keys)
 	if err != nil {
-		return nil, err
+		return nil, wrapLink(msgs.keys, err)
 	}
 	recs, digestSig, err := chooseRecs(keys, msgs.digests)
 	if err != nil {
-		return nil, err
+		return nil, wrapLink(msgs.digests, err)
 	}
 
 	ds := make([]*dns.DS, 0, len(recs))
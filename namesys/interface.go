@@ -68,6 +68,16 @@ type Result struct {
 	CacheTag *string
 	Proof    [][]byte
 	Err      error
+
+	// TTL is how much longer Path may be cached for, or zero if that isn't
+	// known: the full TTL of a fresh resolution, or what's left of it for a
+	// result served from the name system's cache. For a recursive resolution
+	// it's the smallest TTL along the way.
+	TTL time.Duration
+
+	// Hops lists the intermediate /ipns/ names followed to reach Path, in
+	// order.
+	Hops []path.Path
 }
 
 // Resolver is an object capable of resolving names.
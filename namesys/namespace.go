@@ -0,0 +1,173 @@
+package namesys
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	path "github.com/ipfs/go-path"
+	opts "github.com/ipfs/interface-go-ipfs-core/options/namesys"
+)
+
+// NamespaceResolver resolves names under a TLD-like namespace that isn't
+// served by DNS, such as ".crypto" or Handshake names. Resolvers are
+// registered with RegisterNamespace, either as defaults for new name systems
+// or on a single one, and their results are cached and passed through the
+// same proof machinery as DNSLink.
+type NamespaceResolver interface {
+	// ResolveName resolves domain, a name under one of the namespaces the
+	// resolver was registered for, to the path it points to. If needsProof is
+	// set, the resolver should include a proof if it can produce one.
+	ResolveName(ctx context.Context, domain string, needsProof bool) (NamespaceResult, error)
+}
+
+// NamespaceResult is the outcome of a NamespaceResolver lookup.
+type NamespaceResult struct {
+	Path path.Path
+
+	// TTL is how long the result may be cached for. Zero disables caching.
+	TTL time.Duration
+
+	// CacheTag, if set, identifies the record the result came from.
+	CacheTag *string
+
+	// Proof is passed through verbatim as a proof chunk. Its first byte
+	// should identify its format to the verifier; 0 (DNSSEC) and 1 (IPNS
+	// record) are taken.
+	Proof []byte
+}
+
+var (
+	namespacesLk sync.RWMutex
+	namespaces   = map[string]NamespaceResolver{}
+)
+
+// normalizeNamespace returns tld in the form namespaces are keyed by.
+func normalizeNamespace(tld string) (string, error) {
+	norm := strings.ToLower(strings.Trim(tld, "."))
+	if norm == "" || strings.Contains(norm, ".") {
+		return "", fmt.Errorf("invalid namespace %q", tld)
+	}
+	return norm, nil
+}
+
+// RegisterNamespace makes r the default resolver for names ending in tld,
+// e.g. "crypto", in name systems created afterwards. Registered namespaces
+// take precedence over DNS. It's typically called when injecting plugins;
+// name systems that already exist aren't affected.
+func RegisterNamespace(tld string, r NamespaceResolver) error {
+	tld, err := normalizeNamespace(tld)
+	if err != nil {
+		return err
+	}
+
+	namespacesLk.Lock()
+	defer namespacesLk.Unlock()
+	if _, ok := namespaces[tld]; ok {
+		return fmt.Errorf("already have a resolver for namespace %q", tld)
+	}
+	namespaces[tld] = r
+	return nil
+}
+
+// UnregisterNamespace removes the default resolver for tld, if any.
+func UnregisterNamespace(tld string) {
+	tld, err := normalizeNamespace(tld)
+	if err != nil {
+		return
+	}
+
+	namespacesLk.Lock()
+	defer namespacesLk.Unlock()
+	delete(namespaces, tld)
+}
+
+// defaultNamespaces returns a copy of the registered default resolvers.
+func defaultNamespaces() map[string]NamespaceResolver {
+	namespacesLk.RLock()
+	defer namespacesLk.RUnlock()
+	out := make(map[string]NamespaceResolver, len(namespaces))
+	for tld, r := range namespaces {
+		out[tld] = r
+	}
+	return out
+}
+
+// NamespaceRegisterer is implemented by name systems that can have resolvers
+// for extra namespaces added to them.
+type NamespaceRegisterer interface {
+	RegisterNamespace(tld string, r NamespaceResolver) error
+}
+
+// RegisterNamespace makes r the resolver for names ending in tld in this name
+// system only.
+func (ns *mpns) RegisterNamespace(tld string, r NamespaceResolver) error {
+	tld, err := normalizeNamespace(tld)
+	if err != nil {
+		return err
+	}
+
+	ns.namespacesLk.Lock()
+	defer ns.namespacesLk.Unlock()
+	if _, ok := ns.namespaces[tld]; ok {
+		return fmt.Errorf("already have a resolver for namespace %q", tld)
+	}
+	if ns.namespaces == nil {
+		ns.namespaces = make(map[string]NamespaceResolver)
+	}
+	ns.namespaces[tld] = r
+	return nil
+}
+
+// namespaceFor returns the resolver for name's TLD, if any.
+func (ns *mpns) namespaceFor(name string) resolver {
+	domain := strings.TrimSuffix(name, ".")
+	i := strings.LastIndex(domain, ".")
+	if i < 0 {
+		return nil
+	}
+	tld := strings.ToLower(domain[i+1:])
+
+	ns.namespacesLk.RLock()
+	defer ns.namespacesLk.RUnlock()
+	if r, ok := ns.namespaces[tld]; ok {
+		return &namespaceResolver{r}
+	}
+	return nil
+}
+
+// namespaceResolver adapts a NamespaceResolver to the internal resolver
+// interface.
+type namespaceResolver struct {
+	r NamespaceResolver
+}
+
+func (nr *namespaceResolver) resolveOnceAsync(ctx context.Context, name string, needsProof bool, options opts.ResolveOpts) <-chan onceResult {
+	out := make(chan onceResult, 1)
+	segments := strings.SplitN(name, "/", 2)
+
+	go func() {
+		defer close(out)
+
+		res, err := nr.r.ResolveName(ctx, segments[0], needsProof)
+		if err != nil {
+			emitOnceResult(ctx, out, onceResult{err: err})
+			return
+		}
+
+		p := res.Path
+		if len(segments) > 1 {
+			p, err = path.FromSegments("", strings.TrimRight(p.String(), "/"), segments[1])
+		}
+
+		var proof [][]byte
+		if res.Proof != nil {
+			proof = [][]byte{res.Proof}
+		}
+		emitOnceResult(ctx, out, onceResult{value: p, cacheTag: res.CacheTag, proof: proof, ttl: res.TTL, err: err})
+	}()
+
+	return out
+}
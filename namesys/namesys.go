@@ -2,7 +2,12 @@ package namesys
 
 import (
 	"context"
+	"fmt"
+	"math"
+	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	lru "github.com/hashicorp/golang-lru"
@@ -30,6 +35,13 @@ type mpns struct {
 	ipnsPublisher                               Publisher
 
 	cache *lru.Cache
+
+	dnslinkDepth uint32 // accessed atomically
+
+	// namespaces are the resolvers for extra namespaces, starting with the
+	// defaults registered when the name system was created.
+	namespacesLk sync.RWMutex
+	namespaces   map[string]NamespaceResolver
 }
 
 // NewNameSystem will construct the IPFS naming system based on Routing
@@ -45,11 +57,47 @@ func NewNameSystem(r routing.ValueStore, ds ds.Datastore, cachesize int) NameSys
 		ipnsResolver:     NewIpnsResolver(r),
 		ipnsPublisher:    NewIpnsPublisher(r, ds),
 		cache:            cache,
+		namespaces:       defaultNamespaces(),
+	}
+}
+
+// DNSProofCacheSetter is implemented by name systems whose cache of
+// DNSSEC-validated responses can be reconfigured at runtime.
+type DNSProofCacheSetter interface {
+	SetDNSProofCache(cfg DNSProofCacheConfig)
+}
+
+// SetDNSProofCache reconfigures the cache used by the DNS resolver when
+// building DNSSEC proofs. The cache is only rebuilt if cfg changed.
+func (ns *mpns) SetDNSProofCache(cfg DNSProofCacheConfig) {
+	if r, ok := ns.dnsResolver.(*DNSResolver); ok {
+		r.SetProofCache(cfg)
+	}
+}
+
+// DNSClientSubnetSetter is implemented by name systems whose DNS resolver can
+// change the EDNS client-subnet it sends at runtime.
+type DNSClientSubnetSetter interface {
+	SetDNSClientSubnet(subnet *net.IPNet)
+}
+
+// SetDNSClientSubnet sets the EDNS client-subnet sent on DNSSEC lookups. A
+// nil subnet sends none.
+func (ns *mpns) SetDNSClientSubnet(subnet *net.IPNet) {
+	if r, ok := ns.dnsResolver.(*DNSResolver); ok {
+		r.SetClientSubnet(subnet)
 	}
 }
 
 const DefaultResolverCacheTTL = time.Minute
 
+// DefaultDNSLinkDepthLimit is how many names may be followed when resolving a
+// dnslink, unless configured otherwise.
+const DefaultDNSLinkDepthLimit = 16
+
+// MaxDNSLinkDepthLimit is the highest dnslink recursion limit that can be set.
+const MaxDNSLinkDepthLimit = math.MaxUint32
+
 // Resolve implements Resolver.
 func (ns *mpns) Resolve(ctx context.Context, name string, options ...opts.ResolveOpt) (path.Path, error) {
 	if strings.HasPrefix(name, "/ipfs/") {
@@ -60,24 +108,110 @@ func (ns *mpns) Resolve(ctx context.Context, name string, options ...opts.Resolv
 		return path.ParsePath("/ipfs/" + name)
 	}
 
-	return resolve(ctx, ns, name, opts.ProcessOpts(options))
+	o, limit := ns.limitDNSLinkDepth(name, opts.ProcessOpts(options))
+	res := resolveFinal(ctx, ns, name, o)
+	return res.Path, dnslinkRecursionError(name, limit, res)
 }
 
 func (ns *mpns) ResolveAsync(ctx context.Context, name string, options ...opts.ResolveOpt) <-chan Result {
 	res := make(chan Result, 1)
 	if strings.HasPrefix(name, "/ipfs/") {
 		p, err := path.ParsePath(name)
-		res <- Result{p, nil, nil, err}
+		res <- Result{Path: p, Err: err}
 		return res
 	}
 
 	if !strings.HasPrefix(name, "/") {
 		p, err := path.ParsePath("/ipfs/" + name)
-		res <- Result{p, nil, nil, err}
+		res <- Result{Path: p, Err: err}
 		return res
 	}
 
-	return resolveAsync(ctx, ns, name, opts.ProcessOpts(options))
+	o, limit := ns.limitDNSLinkDepth(name, opts.ProcessOpts(options))
+	resCh := resolveAsync(ctx, ns, name, o)
+	if limit == 0 {
+		return resCh
+	}
+
+	out := make(chan Result, 1)
+	go func() {
+		defer close(out)
+		for r := range resCh {
+			r.Err = dnslinkRecursionError(name, limit, r)
+			emitResult(ctx, out, r)
+		}
+	}()
+	return out
+}
+
+// DNSLinkRecursionError is returned when following a dnslink leads through
+// more names than the configured limit allows.
+type DNSLinkRecursionError struct {
+	Name  string
+	Limit uint
+	Hops  []path.Path
+}
+
+func (e *DNSLinkRecursionError) Error() string {
+	hops := make([]string, 0, len(e.Hops)+1)
+	hops = append(hops, e.Name)
+	for _, h := range e.Hops {
+		hops = append(hops, h.String())
+	}
+	return fmt.Sprintf("dnslink recursion limit of %d exceeded: %s", e.Limit, strings.Join(hops, " -> "))
+}
+
+// DNSLinkDepthLimitSetter is implemented by name systems whose dnslink
+// recursion limit can be changed at runtime.
+type DNSLinkDepthLimitSetter interface {
+	SetDNSLinkDepthLimit(limit uint)
+}
+
+// SetDNSLinkDepthLimit sets how many names may be followed when resolving a
+// dnslink. Zero selects DefaultDNSLinkDepthLimit, and limits above
+// MaxDNSLinkDepthLimit are clamped to it.
+func (ns *mpns) SetDNSLinkDepthLimit(limit uint) {
+	if uint64(limit) > MaxDNSLinkDepthLimit {
+		limit = MaxDNSLinkDepthLimit
+	}
+	atomic.StoreUint32(&ns.dnslinkDepth, uint32(limit))
+}
+
+// limitDNSLinkDepth caps the resolution depth if name is a dnslink or in a
+// registered namespace. It
+// returns the limit if it's lower than the depth the caller asked for, or
+// zero.
+func (ns *mpns) limitDNSLinkDepth(name string, options opts.ResolveOpts) (opts.ResolveOpts, uint) {
+	key := strings.SplitN(strings.TrimPrefix(name, ipnsPrefix), "/", 2)[0]
+	if _, err := mh.FromB58String(key); err == nil {
+		return options, 0
+	}
+	if !isd.IsDomain(normalizeDomain(key)) && ns.namespaceFor(key) == nil {
+		return options, 0
+	}
+
+	limit := uint(atomic.LoadUint32(&ns.dnslinkDepth))
+	if limit == 0 {
+		limit = DefaultDNSLinkDepthLimit
+	}
+	if options.Depth != opts.UnlimitedDepth && options.Depth <= limit {
+		return options, 0
+	}
+	options.Depth = limit
+	return options, limit
+}
+
+// dnslinkRecursionError describes res.Err if the dnslink limit stopped the
+// resolution of name.
+func dnslinkRecursionError(name string, limit uint, res Result) error {
+	if limit == 0 || res.Err != ErrResolveRecursion {
+		return res.Err
+	}
+	return &DNSLinkRecursionError{
+		Name:  name,
+		Limit: limit,
+		Hops:  append(res.Hops, res.Path),
+	}
 }
 
 // resolveOnce implements resolver.
@@ -96,31 +230,42 @@ func (ns *mpns) resolveOnceAsync(ctx context.Context, name string, needsProof bo
 	}
 
 	key := segments[2]
+	if _, err := mh.FromB58String(key); err != nil {
+		key = normalizeDomain(key)
+	}
 
-	if p, cacheTag, proof, ok := ns.cacheGet(key); ok && (!needsProof || proof != nil) {
+	if p, cacheTag, proof, ttl, ok := ns.cacheGet(key); ok && (!needsProof || proof != nil) && !bypassCache(ctx) {
 		if len(segments) > 3 {
 			var err error
 			p, err = path.FromSegments("", strings.TrimRight(p.String(), "/"), segments[3])
 			if err != nil {
-				emitOnceResult(ctx, out, onceResult{value: p, cacheTag: cacheTag, proof: proof, err: err})
+				emitOnceResult(ctx, out, onceResult{value: p, cacheTag: cacheTag, proof: proof, ttl: ttl, err: err})
 			}
 		}
 
-		out <- onceResult{value: p, cacheTag: cacheTag, proof: proof}
+		out <- onceResult{value: p, cacheTag: cacheTag, proof: proof, ttl: ttl}
 		close(out)
 		return out
 	}
 
 	// Resolver selection:
 	// 1. if it is a multihash resolve through "ipns".
-	// 2. if it is a domain name, resolve through "dns"
-	// 3. otherwise resolve through the "proquint" resolver
+	// 2. if it is in a registered namespace, resolve through its resolver
+	// 3. if it is a domain name, resolve through "dns"
+	// 4. otherwise resolve through the "proquint" resolver
 
+	// Results are cached for their TTL, except dnslinks: their TTL is only
+	// passed on to the caller, and they're kept for at most
+	// DefaultResolverCacheTTL so that record updates show up promptly.
 	var res resolver
+	var maxLifetime time.Duration
 	if _, err := mh.FromB58String(key); err == nil {
 		res = ns.ipnsResolver
+	} else if nr := ns.namespaceFor(key); nr != nil {
+		res = nr
 	} else if isd.IsDomain(key) {
 		res = ns.dnsResolver
+		maxLifetime = DefaultResolverCacheTTL
 	} else {
 		res = ns.proquintResolver
 	}
@@ -134,7 +279,11 @@ func (ns *mpns) resolveOnceAsync(ctx context.Context, name string, needsProof bo
 			case res, ok := <-resCh:
 				if !ok {
 					if best != nil {
-						ns.cacheSet(key, best.value, best.cacheTag, best.proof, best.ttl)
+						lifetime := best.ttl
+						if maxLifetime > 0 && lifetime > maxLifetime {
+							lifetime = maxLifetime
+						}
+						ns.cacheSet(key, best.value, best.cacheTag, best.proof, best.ttl, lifetime)
 					}
 					return
 				}
@@ -190,6 +339,6 @@ func (ns *mpns) PublishWithEOL(ctx context.Context, name ci.PrivKey, value path.
 	if ttEol := time.Until(eol); ttEol < ttl {
 		ttl = ttEol
 	}
-	ns.cacheSet(peer.Encode(id), value, nil, nil, ttl)
+	ns.cacheSet(peer.Encode(id), value, nil, nil, ttl, ttl)
 	return nil
 }
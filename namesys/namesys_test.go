@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	lru "github.com/hashicorp/golang-lru"
 	ds "github.com/ipfs/go-datastore"
 	dssync "github.com/ipfs/go-datastore/sync"
 	offroute "github.com/ipfs/go-ipfs-routing/offline"
@@ -21,6 +22,7 @@ import (
 
 type mockResolver struct {
 	entries map[string]string
+	ttl     time.Duration
 }
 
 func testResolution(t *testing.T, resolver Resolver, name string, depth uint, expected string, expError error) {
@@ -41,7 +43,7 @@ func testResolution(t *testing.T, resolver Resolver, name string, depth uint, ex
 func (r *mockResolver) resolveOnceAsync(ctx context.Context, name string, needsProof bool, options opts.ResolveOpts) <-chan onceResult {
 	p, err := path.ParsePath(r.entries[name])
 	out := make(chan onceResult, 1)
-	out <- onceResult{value: p, err: err}
+	out <- onceResult{value: p, ttl: r.ttl, err: err}
 	close(out)
 	return out
 }
@@ -163,3 +165,238 @@ func TestPublishWithTTL(t *testing.T) {
 		t.Fatalf("bad cache ttl: expected %s, got %s", eol, entry.eol)
 	}
 }
+
+type mockNamespace map[string]string
+
+func (m mockNamespace) ResolveName(ctx context.Context, domain string, needsProof bool) (NamespaceResult, error) {
+	p, ok := m[domain]
+	if !ok {
+		return NamespaceResult{}, ErrResolveFailed
+	}
+	return NamespaceResult{Path: path.Path(p), TTL: time.Minute}, nil
+}
+
+func TestNamespaceResolution(t *testing.T) {
+	r := &mpns{
+		dnsResolver:      mockResolverOne(),
+		proquintResolver: new(ProquintResolver),
+	}
+	err := r.RegisterNamespace("test", mockNamespace{
+		"example.test": "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD",
+		"loop.test":    "/ipns/loop.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RegisterNamespace(".TEST", mockNamespace{}); err == nil {
+		t.Fatal("expected registering a namespace twice to fail")
+	}
+
+	testResolution(t, r, "/ipns/example.test", opts.DefaultDepthLimit, "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD", nil)
+	testResolution(t, r, "/ipns/example.test/sub", opts.DefaultDepthLimit, "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD/sub", nil)
+	testResolution(t, r, "/ipns/missing.test", opts.DefaultDepthLimit, "", ErrResolveFailed)
+
+	// Namespaces are subject to the dnslink recursion limit.
+	r.SetDNSLinkDepthLimit(3)
+	_, err = r.Resolve(context.Background(), "/ipns/loop.test")
+	if rerr, ok := err.(*DNSLinkRecursionError); !ok || rerr.Limit != 3 {
+		t.Fatalf("expected a DNSLinkRecursionError with a limit of 3, got %v", err)
+	}
+
+	// Namespaces registered on one name system don't leak into others.
+	other := &mpns{dnsResolver: mockResolverOne(), proquintResolver: new(ProquintResolver)}
+	if _, err := other.Resolve(context.Background(), "/ipns/example.test"); err == nil {
+		t.Fatal("expected example.test not to resolve without the namespace")
+	}
+}
+
+func TestRegisterDefaultNamespace(t *testing.T) {
+	err := RegisterNamespace("default", mockNamespace{
+		"example.default": "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { UnregisterNamespace("default") })
+	if err := RegisterNamespace(".DEFAULT", mockNamespace{}); err == nil {
+		t.Fatal("expected registering a namespace twice to fail")
+	}
+	if err := RegisterNamespace("a.b", mockNamespace{}); err == nil {
+		t.Fatal("expected registering a dotted namespace to fail")
+	}
+
+	nsys := NewNameSystem(offroute.NewOfflineRouter(dssync.MutexWrap(ds.NewMapDatastore()), record.NamespacedValidator{}), ds.NewMapDatastore(), 0)
+	testResolution(t, nsys, "/ipns/example.default", opts.DefaultDepthLimit, "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD", nil)
+}
+
+func TestDNSLinkDepthLimit(t *testing.T) {
+	r := &mpns{
+		ipnsResolver: mockResolverOne(),
+		dnsResolver:  mockResolverTwo(),
+	}
+	testResolution(t, r, "/ipns/ipfs.io", opts.DefaultDepthLimit, "/ipfs/Qmcqtw8FfrVSBaRmbWwHxt3AuySBhJLcvmFYi3Lbc4xnwj", nil)
+
+	r.SetDNSLinkDepthLimit(2)
+	_, err := r.Resolve(context.Background(), "/ipns/ipfs.io")
+	rerr, ok := err.(*DNSLinkRecursionError)
+	if !ok {
+		t.Fatalf("expected a DNSLinkRecursionError, got %v", err)
+	}
+	if rerr.Limit != 2 || len(rerr.Hops) != 2 || rerr.Hops[1] != "/ipns/QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy" {
+		t.Fatalf("unexpected error: %+v", rerr)
+	}
+
+	// A lower depth asked for by the caller keeps the usual error.
+	testResolution(t, r, "/ipns/ipfs.io", 1, "/ipns/QmbCMUZw6JFeZ7Wp9jkzbye3Fzp2GGcPgC3nmeUjfVF87n", ErrResolveRecursion)
+
+	for res := range r.ResolveAsync(context.Background(), "/ipns/ipfs.io") {
+		if _, ok := res.Err.(*DNSLinkRecursionError); !ok {
+			t.Fatalf("expected a DNSLinkRecursionError, got %v", res.Err)
+		}
+	}
+
+	// Limits that don't fit are clamped rather than truncated.
+	if ^uint(0) > MaxDNSLinkDepthLimit {
+		r.SetDNSLinkDepthLimit(^uint(0))
+		if r.dnslinkDepth != MaxDNSLinkDepthLimit {
+			t.Fatalf("expected the limit to be clamped to %d, got %d", uint64(MaxDNSLinkDepthLimit), r.dnslinkDepth)
+		}
+	}
+}
+
+func TestResolutionTTL(t *testing.T) {
+	r := &mpns{
+		ipnsResolver:     mockResolverOne(),
+		dnsResolver:      mockResolverTwo(),
+		proquintResolver: new(ProquintResolver),
+	}
+	err := r.RegisterNamespace("ttl", mockNamespace{
+		"direct.ttl":   "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD",
+		"indirect.ttl": "/ipns/QmbCMUZw6JFeZ7Wp9jkzbye3Fzp2GGcPgC3nmeUjfVF87n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"/ipns/direct.ttl", "/ipns/indirect.ttl"} {
+		var ttl time.Duration
+		ctx := context.WithValue(context.Background(), "cache-ttl", &ttl)
+		if _, err := r.Resolve(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+		if ttl != time.Minute {
+			t.Fatalf("%s: expected a TTL of %s, got %s", name, time.Minute, ttl)
+		}
+	}
+
+	// Names resolved without a TTL leave it unset.
+	var ttl time.Duration
+	ctx := context.WithValue(context.Background(), "cache-ttl", &ttl)
+	if _, err := r.Resolve(ctx, "/ipns/ipfs.io"); err != nil {
+		t.Fatal(err)
+	}
+	if ttl != 0 {
+		t.Fatalf("expected no TTL, got %s", ttl)
+	}
+}
+
+func TestCachedResolutionTTL(t *testing.T) {
+	cache, _ := lru.New(8)
+	r := &mpns{
+		ipnsResolver: mockResolverOne(),
+		cache:        cache,
+	}
+	err := r.RegisterNamespace("ttl", mockNamespace{
+		"direct.ttl": "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A fresh resolution reports the full TTL...
+	var ttl time.Duration
+	ctx := context.WithValue(context.Background(), "cache-ttl", &ttl)
+	if _, err := r.Resolve(ctx, "/ipns/direct.ttl"); err != nil {
+		t.Fatal(err)
+	}
+	if ttl != time.Minute {
+		t.Fatalf("fresh resolution: expected a TTL of %s, got %s", time.Minute, ttl)
+	}
+
+	// ...and a cached one what's left of it.
+	ientry, ok := cache.Get("direct.ttl")
+	if !ok {
+		t.Fatal("resolution wasn't cached")
+	}
+	entry := ientry.(cacheEntry)
+	entry.ttlEol = time.Now().Add(30 * time.Second)
+	cache.Add("direct.ttl", entry)
+
+	ttl = 0
+	if _, err := r.Resolve(ctx, "/ipns/direct.ttl"); err != nil {
+		t.Fatal(err)
+	}
+	if ttl <= 0 || ttl > 30*time.Second {
+		t.Fatalf("cached resolution: expected the remaining TTL of at most 30s, got %s", ttl)
+	}
+}
+
+func TestDNSLinkCacheLifetime(t *testing.T) {
+	cache, _ := lru.New(8)
+	r := &mpns{
+		dnsResolver: &mockResolver{
+			entries: map[string]string{
+				"example.com": "/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD",
+			},
+			ttl: time.Hour,
+		},
+		cache: cache,
+	}
+
+	var ttl time.Duration
+	ctx := context.WithValue(context.Background(), "cache-ttl", &ttl)
+	if _, err := r.Resolve(ctx, "/ipns/example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if ttl != time.Hour {
+		t.Fatalf("expected the TXT record's TTL of %s, got %s", time.Hour, ttl)
+	}
+
+	// The dnslink is only cached for a minute, but reports what's left of
+	// its TTL while it is.
+	ientry, ok := cache.Get("example.com")
+	if !ok {
+		t.Fatal("resolution wasn't cached")
+	}
+	if eol := ientry.(cacheEntry).eol; time.Until(eol) > DefaultResolverCacheTTL {
+		t.Fatalf("dnslink cached until %s, more than %s from now", eol, DefaultResolverCacheTTL)
+	}
+
+	ttl = 0
+	if _, err := r.Resolve(ctx, "/ipns/example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if ttl <= DefaultResolverCacheTTL || ttl > time.Hour {
+		t.Fatalf("cached resolution: expected the remaining TTL of the TXT record, got %s", ttl)
+	}
+}
+
+func TestResolveWithoutCache(t *testing.T) {
+	cache, _ := lru.New(8)
+	r := &mpns{
+		ipnsResolver: mockResolverOne(),
+		cache:        cache,
+	}
+	name := "QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy"
+	r.cacheSet(name, "/ipfs/QmP3ouCnU8NNLsW6261pAx2pNLV2E4dQoisB1sgda12Act", nil, nil, time.Minute, time.Minute)
+
+	testResolution(t, r, "/ipns/"+name, opts.DefaultDepthLimit, "/ipfs/QmP3ouCnU8NNLsW6261pAx2pNLV2E4dQoisB1sgda12Act", nil)
+
+	p, err := r.Resolve(WithoutCache(context.Background()), "/ipns/"+name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.String() != "/ipfs/Qmcqtw8FfrVSBaRmbWwHxt3AuySBhJLcvmFYi3Lbc4xnwj" {
+		t.Fatalf("expected the cache to be bypassed, got %s", p)
+	}
+}
@@ -73,9 +73,11 @@ func (r *IpnsResolver) resolveOnceAsync(ctx context.Context, name string, needsP
 	// store before calling GetValue() on the DHT - the DHT will call the
 	// ipns validator, which in turn will get the public key from the peer
 	// store to verify the record signature
+	start := time.Now()
 	_, err = routing.GetPublicKey(r.routing, ctx, pid)
 	if err != nil {
 		log.Debugf("RoutingResolver: could not retrieve public key %s: %s\n", name, err)
+		RecordTiming(ctx, TimingIPNS, name, start)
 		out <- onceResult{err: err}
 		close(out)
 		cancel()
@@ -89,6 +91,7 @@ func (r *IpnsResolver) resolveOnceAsync(ctx context.Context, name string, needsP
 
 	vals, err := r.routing.SearchValue(ctx, ipnsKey, dht.Quorum(int(options.DhtRecordCount)))
 	if err != nil {
+		RecordTiming(ctx, TimingIPNS, name, start)
 		log.Debugf("RoutingResolver: dht get for name %s failed: %s", name, err)
 		out <- onceResult{err: err}
 		close(out)
@@ -105,6 +108,7 @@ func (r *IpnsResolver) resolveOnceAsync(ctx context.Context, name string, needsP
 				if !ok {
 					return
 				}
+				RecordTiming(ctx, TimingIPNS, name, start)
 
 				entry := new(pb.IpnsEntry)
 				err = proto.Unmarshal(val, entry)
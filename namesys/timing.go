@@ -0,0 +1,56 @@
+package namesys
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Stages reported in Timings.
+const (
+	// TimingDNS is a plain DNS TXT lookup.
+	TimingDNS = "dns"
+	// TimingDNSSEC is a DNSSEC-validated TXT lookup. Fetching the records
+	// and validating them are interleaved, so they're reported together.
+	TimingDNSSEC = "dnssec"
+	// TimingIPNS is a query for an IPNS record through the routing system.
+	TimingIPNS = "ipns"
+	// TimingPath is the resolution of a path inside a DAG.
+	TimingPath = "path"
+)
+
+// Timing is how long one stage of a resolution took.
+type Timing struct {
+	Stage    string
+	Name     string
+	Duration time.Duration
+}
+
+// Timings collects how long each stage of resolving a name took. Resolvers
+// add to a Timings found in the context under "resolve-timings".
+type Timings struct {
+	lk      sync.Mutex
+	timings []Timing
+}
+
+// Add records that stage took d for name.
+func (t *Timings) Add(stage, name string, d time.Duration) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	t.timings = append(t.timings, Timing{Stage: stage, Name: name, Duration: d})
+}
+
+// Timings returns the stages recorded so far, in the order they finished.
+func (t *Timings) Timings() []Timing {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	return append([]Timing(nil), t.timings...)
+}
+
+// RecordTiming adds the time elapsed since start to the Timings in ctx, if
+// there is one.
+func RecordTiming(ctx context.Context, stage, name string, start time.Time) {
+	if t, ok := ctx.Value("resolve-timings").(*Timings); ok {
+		t.Add(stage, name, time.Since(start))
+	}
+}
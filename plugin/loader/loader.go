@@ -13,6 +13,7 @@ import (
 	"github.com/ipfs/go-ipfs/core"
 	"github.com/ipfs/go-ipfs/core/coreapi"
 	coredag "github.com/ipfs/go-ipfs/core/coredag"
+	namesys "github.com/ipfs/go-ipfs/namesys"
 	plugin "github.com/ipfs/go-ipfs/plugin"
 	fsrepo "github.com/ipfs/go-ipfs/repo/fsrepo"
 
@@ -232,6 +233,13 @@ func (loader *PluginLoader) Inject() error {
 				return err
 			}
 		}
+		if pl, ok := pl.(plugin.PluginNamesys); ok {
+			err := injectNamesysPlugin(pl)
+			if err != nil {
+				loader.state = loaderFailed
+				return err
+			}
+		}
 	}
 
 	return loader.transition(loaderInjecting, loaderInjected)
@@ -304,6 +312,15 @@ func injectDatastorePlugin(pl plugin.PluginDatastore) error {
 	return fsrepo.AddDatastoreConfigHandler(pl.DatastoreTypeName(), pl.DatastoreConfigParser())
 }
 
+func injectNamesysPlugin(pl plugin.PluginNamesys) error {
+	for tld, r := range pl.Namespaces() {
+		if err := namesys.RegisterNamespace(tld, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func injectIPLDPlugin(pl plugin.PluginIPLD) error {
 	err := pl.RegisterBlockDecoders(ipld.DefaultBlockDecoder)
 	if err != nil {
@@ -0,0 +1,15 @@
+package plugin
+
+import (
+	"github.com/ipfs/go-ipfs/namesys"
+)
+
+// PluginNamesys is an interface that can be implemented to add resolvers for
+// additional naming systems
+type PluginNamesys interface {
+	Plugin
+
+	// Namespaces maps each TLD-like namespace the plugin handles (e.g.
+	// "crypto") to its resolver.
+	Namespaces() map[string]namesys.NamespaceResolver
+}
@@ -44,6 +44,14 @@ test_expect_success "'ipfs bitswap wantlist -p' output looks good" '
   test_must_be_empty wantlist_p_out
 '
 
+test_expect_success "'ipfs bitswap wantlist -p' rejects local-only options for other peers" '
+  OTHERID=$(ipfs key gen --type=ed25519 wantlist-other) &&
+  test_must_fail ipfs bitswap wantlist -p "$OTHERID" --verbose 2>wantlist_p_err &&
+  grep "only apply to the local wantlist" wantlist_p_err &&
+  test_must_fail ipfs bitswap wantlist -p "$OTHERID" --sessions &&
+  test_must_fail ipfs bitswap wantlist -p "$OTHERID" --session 1
+'
+
 test_expect_success "hash was removed from wantlist" '
   ipfs bitswap wantlist > wantlist_out &&
   test_must_be_empty wantlist_out
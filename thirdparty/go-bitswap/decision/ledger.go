@@ -0,0 +1,108 @@
+package decision
+
+import (
+	"sync"
+	"time"
+
+	wl "github.com/ipfs/go-bitswap/wantlist"
+
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+func newLedger(p peer.ID) *ledger {
+	return &ledger{
+		wantList: wl.New(),
+		Partner:  p,
+	}
+}
+
+// ledger stores the data exchange relationship between two peers.
+// NOT threadsafe
+type ledger struct {
+	// Partner is the remote Peer.
+	Partner peer.ID
+
+	// Accounting tracks bytes sent and received.
+	Accounting debtRatio
+
+	// lastExchange is the time of the last data exchange.
+	lastExchange time.Time
+
+	// These scores keep track of how useful we think this peer is. Short
+	// tracks short-term usefulness and long tracks long-term usefulness.
+	shortScore, longScore float64
+	// Score keeps track of the score used in the peer tagger. We track it
+	// here to avoid unnecessarily updating the tags in the connection manager.
+	score int
+
+	// exchangeCount is the number of exchanges with this peer
+	exchangeCount uint64
+
+	// wantList is a (bounded, small) set of keys that Partner desires.
+	wantList *wl.Wantlist
+
+	// ref is the reference count for this ledger, its used to ensure we
+	// don't drop the reference to this ledger in multi-connection scenarios
+	ref int
+
+	lk sync.Mutex
+}
+
+// Receipt is a summary of the ledger for a given peer
+// collecting various pieces of aggregated data for external
+// reporting purposes.
+type Receipt struct {
+	Peer      string
+	Value     float64
+	Sent      uint64
+	Recv      uint64
+	Exchanged uint64
+}
+
+type debtRatio struct {
+	BytesSent uint64
+	BytesRecv uint64
+}
+
+// Value returns the debt ratio, sent:receive.
+func (dr *debtRatio) Value() float64 {
+	return float64(dr.BytesSent) / float64(dr.BytesRecv+1)
+}
+
+// Score returns the debt _score_ on a 0-1 scale.
+func (dr *debtRatio) Score() float64 {
+	if dr.BytesRecv == 0 {
+		return 0
+	}
+	return float64(dr.BytesRecv) / float64(dr.BytesRecv+dr.BytesSent)
+}
+
+func (l *ledger) SentBytes(n int) {
+	l.exchangeCount++
+	l.lastExchange = time.Now()
+	l.Accounting.BytesSent += uint64(n)
+}
+
+func (l *ledger) ReceivedBytes(n int) {
+	l.exchangeCount++
+	l.lastExchange = time.Now()
+	l.Accounting.BytesRecv += uint64(n)
+}
+
+func (l *ledger) Wants(k cid.Cid, priority int) {
+	log.Debugf("peer %s wants %s", l.Partner, k)
+	l.wantList.Add(k, priority)
+}
+
+func (l *ledger) CancelWant(k cid.Cid) {
+	l.wantList.Remove(k)
+}
+
+func (l *ledger) WantListContains(k cid.Cid) (wl.Entry, bool) {
+	return l.wantList.Contains(k)
+}
+
+func (l *ledger) ExchangeCount() uint64 {
+	return l.exchangeCount
+}
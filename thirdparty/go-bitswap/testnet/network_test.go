@@ -0,0 +1,102 @@
+package bitswap
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	bsmsg "github.com/ipfs/go-bitswap/message"
+	bsnet "github.com/ipfs/go-bitswap/network"
+
+	blocks "github.com/ipfs/go-block-format"
+	delay "github.com/ipfs/go-ipfs-delay"
+	mockrouting "github.com/ipfs/go-ipfs-routing/mock"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-testing/net"
+)
+
+func TestSendMessageAsyncButWaitForResponse(t *testing.T) {
+	net := VirtualNetwork(mockrouting.NewServer(), delay.Fixed(0))
+	responderPeer := tnet.RandIdentityOrFatal(t)
+	waiter := net.Adapter(tnet.RandIdentityOrFatal(t))
+	responder := net.Adapter(responderPeer)
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+
+	expectedStr := "received async"
+
+	responder.SetDelegate(lambda(func(
+		ctx context.Context,
+		fromWaiter peer.ID,
+		msgFromWaiter bsmsg.BitSwapMessage) {
+
+		msgToWaiter := bsmsg.New(true)
+		msgToWaiter.AddBlock(blocks.NewBlock([]byte(expectedStr)))
+		err := waiter.SendMessage(ctx, fromWaiter, msgToWaiter)
+		if err != nil {
+			t.Error(err)
+		}
+	}))
+
+	waiter.SetDelegate(lambda(func(
+		ctx context.Context,
+		fromResponder peer.ID,
+		msgFromResponder bsmsg.BitSwapMessage) {
+
+		// TODO assert that this came from the correct peer and that the message contents are as expected
+		ok := false
+		for _, b := range msgFromResponder.Blocks() {
+			if string(b.RawData()) == expectedStr {
+				wg.Done()
+				ok = true
+			}
+		}
+
+		if !ok {
+			t.Fatal("Message not received from the responder")
+		}
+	}))
+
+	messageSentAsync := bsmsg.New(true)
+	messageSentAsync.AddBlock(blocks.NewBlock([]byte("data")))
+	errSending := waiter.SendMessage(
+		context.Background(), responderPeer.ID(), messageSentAsync)
+	if errSending != nil {
+		t.Fatal(errSending)
+	}
+
+	wg.Wait() // until waiter delegate function is executed
+}
+
+type receiverFunc func(ctx context.Context, p peer.ID,
+	incoming bsmsg.BitSwapMessage)
+
+// lambda returns a Receiver instance given a receiver function
+func lambda(f receiverFunc) bsnet.Receiver {
+	return &lambdaImpl{
+		f: f,
+	}
+}
+
+type lambdaImpl struct {
+	f func(ctx context.Context, p peer.ID, incoming bsmsg.BitSwapMessage)
+}
+
+func (lam *lambdaImpl) ReceiveMessage(ctx context.Context,
+	p peer.ID, incoming bsmsg.BitSwapMessage) {
+	lam.f(ctx, p, incoming)
+}
+
+func (lam *lambdaImpl) ReceiveError(err error) {
+	// TODO log error
+}
+
+func (lam *lambdaImpl) PeerConnected(p peer.ID) {
+	// TODO
+}
+func (lam *lambdaImpl) PeerDisconnected(peer.ID) {
+	// TODO
+}
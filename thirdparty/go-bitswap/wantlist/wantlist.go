@@ -0,0 +1,399 @@
+// Package wantlist implements an object for bitswap that contains the keys
+// that a given peer wants.
+package wantlist
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// SessionTrackedWantlist is a list of wants that also track which bitswap
+// sessions have requested them
+type SessionTrackedWantlist struct {
+	set map[cid.Cid]sessionTrackedEntry
+
+	// added records when each want was added. It's nil unless the
+	// wantlist was created by NewTimedSessionTrackedWantlist.
+	added map[cid.Cid]time.Time
+}
+
+// Wantlist is a raw list of wanted blocks and their priorities
+type Wantlist struct {
+	set map[cid.Cid]Entry
+}
+
+// Entry is an entry in a want list, consisting of a cid and its priority
+type Entry struct {
+	Cid      cid.Cid
+	Priority int
+}
+
+// EntryInfo is a wantlist entry along with diagnostic metadata about who wants
+// it and for how long. Added is zero unless the wantlist records it.
+type EntryInfo struct {
+	Entry
+	Added    time.Time
+	Sessions []uint64
+}
+
+// sessionTrackedEntry is stored by value, and keeps its sessions in a sorted
+// slice rather than a map: nearly every want is tracked by one or two
+// sessions, and a map per entry costs hundreds of bytes on busy nodes.
+type sessionTrackedEntry struct {
+	Entry
+	sessions []uint64
+}
+
+// addSession adds ses to the entry's sessions, and reports whether it wasn't
+// there already.
+func (e *sessionTrackedEntry) addSession(ses uint64) bool {
+	i := sort.Search(len(e.sessions), func(i int) bool { return e.sessions[i] >= ses })
+	if i < len(e.sessions) && e.sessions[i] == ses {
+		return false
+	}
+	e.sessions = append(e.sessions, 0)
+	copy(e.sessions[i+1:], e.sessions[i:])
+	e.sessions[i] = ses
+	return true
+}
+
+// removeSession removes ses from the entry's sessions, if present.
+func (e *sessionTrackedEntry) removeSession(ses uint64) {
+	i := sort.Search(len(e.sessions), func(i int) bool { return e.sessions[i] >= ses })
+	if i < len(e.sessions) && e.sessions[i] == ses {
+		e.sessions = append(e.sessions[:i], e.sessions[i+1:]...)
+	}
+}
+
+// NewRefEntry creates a new reference tracked wantlist entry.
+func NewRefEntry(c cid.Cid, p int) Entry {
+	return Entry{
+		Cid:      c,
+		Priority: p,
+	}
+}
+
+// entryBefore reports whether a sorts before b: by descending priority, then
+// by CID, so that the order is stable across calls.
+func entryBefore(a, b Entry) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	return a.Cid.KeyString() < b.Cid.KeyString()
+}
+
+type entrySlice []Entry
+
+func (es entrySlice) Len() int           { return len(es) }
+func (es entrySlice) Swap(i, j int)      { es[i], es[j] = es[j], es[i] }
+func (es entrySlice) Less(i, j int) bool { return entryBefore(es[i], es[j]) }
+
+// entryHeap is a heap with the entry that sorts last on top, used to keep the
+// first entries without sorting all of them.
+type entryHeap []Entry
+
+func (h entryHeap) Len() int            { return len(h) }
+func (h entryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h entryHeap) Less(i, j int) bool  { return entryBefore(h[j], h[i]) }
+func (h *entryHeap) Push(x interface{}) { *h = append(*h, x.(Entry)) }
+func (h *entryHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+const maxInt = int(^uint(0) >> 1)
+
+// sortedRange returns the entries at positions [offset, offset+limit) of the
+// n or so entries produced by each, ordered by priority and then CID. Only
+// offset+limit entries are kept and sorted. If limit isn't positive, all
+// entries from offset on are returned.
+func sortedRange(each func(func(Entry)), n, offset, limit int) []Entry {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= n {
+		return nil
+	}
+
+	var es []Entry
+	if limit <= 0 {
+		es = make([]Entry, 0, n)
+		each(func(e Entry) { es = append(es, e) })
+	} else {
+		k := maxInt
+		if limit <= maxInt-offset {
+			k = offset + limit
+		}
+		size := k
+		if size > n {
+			size = n
+		}
+		h := make(entryHeap, 0, size)
+		each(func(e Entry) {
+			if len(h) < k {
+				h = append(h, e)
+				if len(h) == k {
+					heap.Init(&h)
+				}
+			} else if entryBefore(e, h[0]) {
+				h[0] = e
+				heap.Fix(&h, 0)
+			}
+		})
+		es = h
+	}
+
+	sort.Sort(entrySlice(es))
+	if offset >= len(es) {
+		return nil
+	}
+	return es[offset:]
+}
+
+// NewSessionTrackedWantlist generates a new SessionTrackedWantList.
+func NewSessionTrackedWantlist() *SessionTrackedWantlist {
+	return &SessionTrackedWantlist{
+		set: make(map[cid.Cid]sessionTrackedEntry),
+	}
+}
+
+// NewTimedSessionTrackedWantlist generates a new SessionTrackedWantList that
+// also records when each want was added, for EntryInfos and
+// SessionEntryInfos.
+func NewTimedSessionTrackedWantlist() *SessionTrackedWantlist {
+	w := NewSessionTrackedWantlist()
+	w.added = make(map[cid.Cid]time.Time)
+	return w
+}
+
+// New generates a new raw Wantlist
+func New() *Wantlist {
+	return &Wantlist{
+		set: make(map[cid.Cid]Entry),
+	}
+}
+
+// Add adds the given cid to the wantlist with the specified priority, governed
+// by the session ID 'ses'.  if a cid is added under multiple session IDs, then
+// it must be removed by each of those sessions before it is no longer 'in the
+// wantlist'. Calls to Add are idempotent given the same arguments. Subsequent
+// calls with different values for priority will not update the priority.
+// TODO: think through priority changes here
+// Add returns true if the cid did not exist in the wantlist before this call
+// (even if it was under a different session).
+func (w *SessionTrackedWantlist) Add(c cid.Cid, priority int, ses uint64) bool {
+	return w.AddEntry(Entry{Cid: c, Priority: priority}, ses)
+}
+
+// AddEntry adds given Entry to the wantlist. For more information see Add method.
+func (w *SessionTrackedWantlist) AddEntry(e Entry, ses uint64) bool {
+	if ex, ok := w.set[e.Cid]; ok {
+		if ex.addSession(ses) {
+			w.set[e.Cid] = ex
+		}
+		return false
+	}
+	w.set[e.Cid] = sessionTrackedEntry{
+		Entry:    e,
+		sessions: []uint64{ses},
+	}
+	if w.added != nil {
+		w.added[e.Cid] = time.Now()
+	}
+	return true
+}
+
+// Remove removes the given cid from being tracked by the given session.
+// 'true' is returned if this call to Remove removed the final session ID
+// tracking the cid. (meaning true will be returned iff this call caused the
+// value of 'Contains(c)' to change from true to false)
+func (w *SessionTrackedWantlist) Remove(c cid.Cid, ses uint64) bool {
+	e, ok := w.set[c]
+	if !ok {
+		return false
+	}
+
+	e.removeSession(ses)
+	if len(e.sessions) == 0 {
+		delete(w.set, c)
+		delete(w.added, c)
+		return true
+	}
+	w.set[c] = e
+	return false
+}
+
+// Contains returns true if the given cid is in the wantlist tracked by one or
+// more sessions.
+func (w *SessionTrackedWantlist) Contains(k cid.Cid) (Entry, bool) {
+	e, ok := w.set[k]
+	if !ok {
+		return Entry{}, false
+	}
+	return e.Entry, true
+}
+
+// Entries returns all wantlist entries for a given session tracked want list.
+func (w *SessionTrackedWantlist) Entries() []Entry {
+	es := make([]Entry, 0, len(w.set))
+	for _, e := range w.set {
+		es = append(es, e.Entry)
+	}
+	return es
+}
+
+// EntryInfos returns all wantlist entries along with the time they were first
+// added and the sessions currently tracking them.
+func (w *SessionTrackedWantlist) EntryInfos() []EntryInfo {
+	es := make([]EntryInfo, 0, len(w.set))
+	for _, e := range w.set {
+		es = append(es, EntryInfo{
+			Entry:    e.Entry,
+			Added:    w.added[e.Cid],
+			Sessions: append([]uint64(nil), e.sessions...),
+		})
+	}
+	return es
+}
+
+// SessionEntryInfos returns the wantlist entries tracked by the given session,
+// along with the time they were first added and all the sessions tracking them.
+func (w *SessionTrackedWantlist) SessionEntryInfos(ses uint64) []EntryInfo {
+	var es []EntryInfo
+	for _, e := range w.set {
+		i := sort.Search(len(e.sessions), func(i int) bool { return e.sessions[i] >= ses })
+		if i == len(e.sessions) || e.sessions[i] != ses {
+			continue
+		}
+		es = append(es, EntryInfo{
+			Entry:    e.Entry,
+			Added:    w.added[e.Cid],
+			Sessions: append([]uint64(nil), e.sessions...),
+		})
+	}
+	return es
+}
+
+// Sessions returns the sessions tracking at least one want, along with how
+// many wants each of them tracks.
+func (w *SessionTrackedWantlist) Sessions() map[uint64]int {
+	out := make(map[uint64]int)
+	for _, e := range w.set {
+		for _, ses := range e.sessions {
+			out[ses]++
+		}
+	}
+	return out
+}
+
+// SortedEntries returns wantlist entries ordered by priority.
+func (w *SessionTrackedWantlist) SortedEntries() []Entry {
+	es := w.Entries()
+	sort.Sort(entrySlice(es))
+	return es
+}
+
+// SortedEntriesRange returns limit wantlist entries ordered by priority,
+// skipping the first offset. Entries with the same priority are ordered by
+// CID, so consecutive ranges page through the wantlist. Unlike SortedEntries, it doesn't copy and sort
+// the whole wantlist, so it's cheap for callers that only want the top few
+// entries. If limit isn't positive, all entries from offset on are returned.
+func (w *SessionTrackedWantlist) SortedEntriesRange(offset, limit int) []Entry {
+	return sortedRange(func(f func(Entry)) {
+		for _, e := range w.set {
+			f(e.Entry)
+		}
+	}, len(w.set), offset, limit)
+}
+
+// Len returns the number of entries in a wantlist.
+func (w *SessionTrackedWantlist) Len() int {
+	return len(w.set)
+}
+
+// CopyWants copies all wants from one SessionTrackWantlist to another (along with
+// the session data)
+func (w *SessionTrackedWantlist) CopyWants(to *SessionTrackedWantlist) {
+	for _, e := range w.set {
+		for _, k := range e.sessions {
+			to.AddEntry(e.Entry, k)
+		}
+	}
+}
+
+// Len returns the number of entries in a wantlist.
+func (w *Wantlist) Len() int {
+	return len(w.set)
+}
+
+// Add adds an entry in a wantlist from CID & Priority, if not already present.
+func (w *Wantlist) Add(c cid.Cid, priority int) bool {
+	if _, ok := w.set[c]; ok {
+		return false
+	}
+
+	w.set[c] = Entry{
+		Cid:      c,
+		Priority: priority,
+	}
+
+	return true
+}
+
+// AddEntry adds an entry to a wantlist if not already present.
+func (w *Wantlist) AddEntry(e Entry) bool {
+	if _, ok := w.set[e.Cid]; ok {
+		return false
+	}
+	w.set[e.Cid] = e
+	return true
+}
+
+// Remove removes the given cid from the wantlist.
+func (w *Wantlist) Remove(c cid.Cid) bool {
+	_, ok := w.set[c]
+	if !ok {
+		return false
+	}
+
+	delete(w.set, c)
+	return true
+}
+
+// Contains returns the entry, if present, for the given CID, plus whether it
+// was present.
+func (w *Wantlist) Contains(c cid.Cid) (Entry, bool) {
+	e, ok := w.set[c]
+	return e, ok
+}
+
+// Entries returns all wantlist entries for a want list.
+func (w *Wantlist) Entries() []Entry {
+	es := make([]Entry, 0, len(w.set))
+	for _, e := range w.set {
+		es = append(es, e)
+	}
+	return es
+}
+
+// SortedEntries returns wantlist entries ordered by priority.
+func (w *Wantlist) SortedEntries() []Entry {
+	es := w.Entries()
+	sort.Sort(entrySlice(es))
+	return es
+}
+
+// SortedEntriesRange returns limit wantlist entries ordered by priority,
+// skipping the first offset. See SessionTrackedWantlist.SortedEntriesRange.
+func (w *Wantlist) SortedEntriesRange(offset, limit int) []Entry {
+	return sortedRange(func(f func(Entry)) {
+		for _, e := range w.set {
+			f(e)
+		}
+	}, len(w.set), offset, limit)
+}
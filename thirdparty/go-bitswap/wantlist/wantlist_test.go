@@ -0,0 +1,371 @@
+package wantlist
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+var testcids []cid.Cid
+
+func init() {
+	strs := []string{
+		"QmQL8LqkEgYXaDHdNYCG2mmpow7Sp8Z8Kt3QS688vyBeC7",
+		"QmcBDsdjgSXU7BP4A4V8LJCXENE5xVwnhrhRGVTJr9YCVj",
+		"QmQakgd2wDxc3uUF4orGdEm28zUT9Mmimp5pyPG2SFS9Gj",
+	}
+	for _, s := range strs {
+		c, err := cid.Decode(s)
+		if err != nil {
+			panic(err)
+		}
+		testcids = append(testcids, c)
+	}
+
+}
+
+type wli interface {
+	Contains(cid.Cid) (Entry, bool)
+}
+
+func assertHasCid(t *testing.T, w wli, c cid.Cid) {
+	e, ok := w.Contains(c)
+	if !ok {
+		t.Fatal("expected to have ", c)
+	}
+	if !e.Cid.Equals(c) {
+		t.Fatal("returned entry had wrong cid value")
+	}
+}
+
+func assertNotHasCid(t *testing.T, w wli, c cid.Cid) {
+	_, ok := w.Contains(c)
+	if ok {
+		t.Fatal("expected not to have ", c)
+	}
+}
+
+func TestBasicWantlist(t *testing.T) {
+	wl := New()
+
+	if !wl.Add(testcids[0], 5) {
+		t.Fatal("expected true")
+	}
+	assertHasCid(t, wl, testcids[0])
+	if !wl.Add(testcids[1], 4) {
+		t.Fatal("expected true")
+	}
+	assertHasCid(t, wl, testcids[0])
+	assertHasCid(t, wl, testcids[1])
+
+	if wl.Len() != 2 {
+		t.Fatal("should have had two items")
+	}
+
+	if wl.Add(testcids[1], 4) {
+		t.Fatal("add shouldnt report success on second add")
+	}
+	assertHasCid(t, wl, testcids[0])
+	assertHasCid(t, wl, testcids[1])
+
+	if wl.Len() != 2 {
+		t.Fatal("should have had two items")
+	}
+
+	if !wl.Remove(testcids[0]) {
+		t.Fatal("should have gotten true")
+	}
+
+	assertHasCid(t, wl, testcids[1])
+	if _, has := wl.Contains(testcids[0]); has {
+		t.Fatal("shouldnt have this cid")
+	}
+}
+
+func TestSessionTrackedWantlist(t *testing.T) {
+	wl := NewSessionTrackedWantlist()
+
+	if !wl.Add(testcids[0], 5, 1) {
+		t.Fatal("should have added")
+	}
+	assertHasCid(t, wl, testcids[0])
+	if wl.Remove(testcids[0], 2) {
+		t.Fatal("shouldnt have removed")
+	}
+	assertHasCid(t, wl, testcids[0])
+	if wl.Add(testcids[0], 5, 1) {
+		t.Fatal("shouldnt have added")
+	}
+	assertHasCid(t, wl, testcids[0])
+	if !wl.Remove(testcids[0], 1) {
+		t.Fatal("should have removed")
+	}
+	assertNotHasCid(t, wl, testcids[0])
+}
+
+func testCids(n int) []cid.Cid {
+	out := make([]cid.Cid, n)
+	for i := range out {
+		h, err := mh.Sum([]byte(fmt.Sprint(i)), mh.SHA2_256, -1)
+		if err != nil {
+			panic(err)
+		}
+		out[i] = cid.NewCidV1(cid.Raw, h)
+	}
+	return out
+}
+
+func TestSessionTrackedWantlistAddRemove(t *testing.T) {
+	cids := testCids(100)
+	wl := NewSessionTrackedWantlist()
+
+	for i, c := range cids {
+		if !wl.Add(c, i, 1) {
+			t.Fatal("expected first add to report a new entry")
+		}
+		if wl.Add(c, i, 2) {
+			t.Fatal("expected second add to report an existing entry")
+		}
+	}
+	if wl.Len() != len(cids) {
+		t.Fatalf("expected %d entries, got %d", len(cids), wl.Len())
+	}
+
+	for _, c := range cids {
+		if wl.Remove(c, 1) {
+			t.Fatal("entry should still be tracked by session 2")
+		}
+		if _, ok := wl.Contains(c); !ok {
+			t.Fatal("expected entry to still be present")
+		}
+		if !wl.Remove(c, 2) {
+			t.Fatal("expected entry to be removed with its last session")
+		}
+	}
+	if wl.Len() != 0 {
+		t.Fatalf("expected empty wantlist, got %d entries", wl.Len())
+	}
+}
+
+func TestSessionTrackedWantlistSessions(t *testing.T) {
+	c := testCids(1)[0]
+	wl := NewSessionTrackedWantlist()
+	for _, ses := range []uint64{3, 1, 2, 1} {
+		wl.Add(c, 0, ses)
+	}
+	wl.Remove(c, 2)
+
+	infos := wl.EntryInfos()
+	if len(infos) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(infos))
+	}
+	if s := infos[0].Sessions; len(s) != 2 || s[0] != 1 || s[1] != 3 {
+		t.Fatalf("expected sessions [1 3], got %v", s)
+	}
+}
+
+func TestSessionEntryInfos(t *testing.T) {
+	cids := testCids(3)
+	wl := NewSessionTrackedWantlist()
+	wl.Add(cids[0], 0, 1)
+	wl.Add(cids[1], 0, 1)
+	wl.Add(cids[1], 0, 2)
+	wl.Add(cids[2], 0, 2)
+	wl.Remove(cids[0], 1)
+
+	sessions := wl.Sessions()
+	if len(sessions) != 2 || sessions[1] != 1 || sessions[2] != 2 {
+		t.Fatalf("expected sessions map[1:1 2:2], got %v", sessions)
+	}
+
+	infos := wl.SessionEntryInfos(1)
+	if len(infos) != 1 || !infos[0].Cid.Equals(cids[1]) {
+		t.Fatalf("expected session 1 to want only %s, got %v", cids[1], infos)
+	}
+	if s := infos[0].Sessions; len(s) != 2 || s[0] != 1 || s[1] != 2 {
+		t.Fatalf("expected sessions [1 2], got %v", s)
+	}
+	if infos := wl.SessionEntryInfos(3); len(infos) != 0 {
+		t.Fatalf("expected no wants for session 3, got %v", infos)
+	}
+}
+
+func TestTimedSessionTrackedWantlist(t *testing.T) {
+	cids := testCids(2)
+
+	wl := NewSessionTrackedWantlist()
+	wl.Add(cids[0], 0, 1)
+	if infos := wl.EntryInfos(); len(infos) != 1 || !infos[0].Added.IsZero() {
+		t.Fatalf("expected no add time on an untimed wantlist, got %v", infos)
+	}
+
+	before := time.Now()
+	twl := NewTimedSessionTrackedWantlist()
+	twl.Add(cids[0], 0, 1)
+	twl.Add(cids[1], 0, 1)
+	twl.Remove(cids[1], 1)
+	infos := twl.EntryInfos()
+	if len(infos) != 1 || infos[0].Added.Before(before) || infos[0].Added.After(time.Now()) {
+		t.Fatalf("expected %s to have been added just now, got %v", cids[0], infos)
+	}
+}
+
+func TestSortedEntriesRange(t *testing.T) {
+	cids := testCids(100)
+	wl := New()
+	stwl := NewSessionTrackedWantlist()
+	for i, c := range cids {
+		wl.Add(c, i)
+		stwl.Add(c, i, 1)
+	}
+
+	for _, ranged := range []func(offset, limit int) []Entry{wl.SortedEntriesRange, stwl.SortedEntriesRange} {
+		for _, test := range []struct {
+			offset, limit int
+			first, n      int
+		}{
+			{0, 10, 99, 10},
+			{10, 5, 89, 5},
+			{95, 10, 4, 5},
+			{0, 0, 99, 100},
+			{98, 0, 1, 2},
+			{100, 10, 0, 0},
+		} {
+			es := ranged(test.offset, test.limit)
+			if len(es) != test.n {
+				t.Fatalf("range(%d, %d): expected %d entries, got %d", test.offset, test.limit, test.n, len(es))
+			}
+			for i, e := range es {
+				if e.Priority != test.first-i {
+					t.Fatalf("range(%d, %d): entry %d has priority %d, expected %d", test.offset, test.limit, i, e.Priority, test.first-i)
+				}
+			}
+		}
+	}
+}
+
+func TestSortedEntriesRangePaging(t *testing.T) {
+	cids := testCids(100)
+	wl := New()
+	stwl := NewSessionTrackedWantlist()
+	for i, c := range cids {
+		// Only a few distinct priorities, so most pages cut through
+		// entries of equal priority.
+		wl.Add(c, i%3)
+		stwl.Add(c, i%3, 1)
+	}
+
+	for _, ranged := range []func(offset, limit int) []Entry{wl.SortedEntriesRange, stwl.SortedEntriesRange} {
+		all := ranged(0, 0)
+		var paged []Entry
+		for offset := 0; offset < len(cids); offset += 7 {
+			paged = append(paged, ranged(offset, 7)...)
+		}
+		if len(paged) != len(all) {
+			t.Fatalf("expected %d entries across pages, got %d", len(all), len(paged))
+		}
+		seen := make(map[cid.Cid]bool)
+		for i, e := range paged {
+			if !e.Cid.Equals(all[i].Cid) {
+				t.Fatalf("entry %d: pages returned %s, full range returned %s", i, e.Cid, all[i].Cid)
+			}
+			if seen[e.Cid] {
+				t.Fatalf("entry %s returned on more than one page", e.Cid)
+			}
+			seen[e.Cid] = true
+		}
+
+		// Huge offsets and limits from API callers are clamped.
+		const maxInt = int(^uint(0) >> 1)
+		if es := ranged(1, maxInt); len(es) != len(cids)-1 {
+			t.Fatalf("expected %d entries, got %d", len(cids)-1, len(es))
+		}
+		if es := ranged(maxInt, maxInt); len(es) != 0 {
+			t.Fatalf("expected no entries, got %d", len(es))
+		}
+	}
+}
+
+func TestSessionTrackedWantlistCopyWants(t *testing.T) {
+	cids := testCids(100)
+	a := NewSessionTrackedWantlist()
+	b := NewSessionTrackedWantlist()
+	for i, c := range cids {
+		a.Add(c, i, 1)
+		b.Add(c, i, 2)
+	}
+
+	a.CopyWants(b)
+
+	infos := b.EntryInfos()
+	if len(infos) != len(cids) {
+		t.Fatalf("expected %d entries, got %d", len(cids), len(infos))
+	}
+	for _, info := range infos {
+		if len(info.Sessions) != 2 {
+			t.Fatalf("expected %s to be wanted by both sessions, got %v", info.Cid, info.Sessions)
+		}
+	}
+}
+
+// benchmarkWantlistSize is the number of entries the single-threaded
+// benchmarks run against, about what a busy relay node holds.
+const benchmarkWantlistSize = 1000000
+
+var benchmarkCids []cid.Cid
+
+func fullWantlist(b *testing.B) (*SessionTrackedWantlist, []cid.Cid) {
+	if benchmarkCids == nil {
+		benchmarkCids = testCids(benchmarkWantlistSize)
+	}
+	wl := NewSessionTrackedWantlist()
+	for i, c := range benchmarkCids {
+		wl.Add(c, i, uint64(i%4))
+	}
+	b.ResetTimer()
+	b.ReportAllocs()
+	return wl, benchmarkCids
+}
+
+func BenchmarkSessionTrackedWantlistAdd(b *testing.B) {
+	wl, cids := fullWantlist(b)
+	for i := 0; i < b.N; i++ {
+		wl.Add(cids[i%len(cids)], i, uint64(4+i%4))
+	}
+}
+
+func BenchmarkSessionTrackedWantlistRemove(b *testing.B) {
+	wl, cids := fullWantlist(b)
+	for i := 0; i < b.N; i++ {
+		c := cids[i%len(cids)]
+		ses := uint64(i % 4)
+		wl.Remove(c, ses)
+		b.StopTimer()
+		wl.Add(c, i, ses)
+		b.StartTimer()
+	}
+}
+
+func BenchmarkSessionTrackedWantlistEntries(b *testing.B) {
+	wl, _ := fullWantlist(b)
+	for i := 0; i < b.N; i++ {
+		wl.Entries()
+	}
+}
+
+func BenchmarkSessionTrackedWantlistSortedEntries(b *testing.B) {
+	wl, _ := fullWantlist(b)
+	for i := 0; i < b.N; i++ {
+		wl.SortedEntries()
+	}
+}
+
+func BenchmarkSessionTrackedWantlistSortedEntriesRange(b *testing.B) {
+	wl, _ := fullWantlist(b)
+	for i := 0; i < b.N; i++ {
+		wl.SortedEntriesRange(0, 32)
+	}
+}
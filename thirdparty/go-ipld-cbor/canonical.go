@@ -0,0 +1,182 @@
+package cbornode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ErrNotCanonical is returned by Put in strict mode when an object doesn't
+// encode to canonical DAG-CBOR.
+var ErrNotCanonical = errors.New("object is not canonical DAG-CBOR")
+
+// CBOR major types.
+const (
+	majUint = iota
+	majNegInt
+	majBytes
+	majString
+	majArray
+	majMap
+	majTag
+	majOther
+)
+
+// cidTag is the only tag allowed in DAG-CBOR, marking a link.
+const cidTag = 42
+
+// maxCanonicalDepth bounds how deeply VerifyCanonical will recurse into
+// nested arrays and maps.
+const maxCanonicalDepth = 1024
+
+// VerifyCanonical checks that data is a single canonical DAG-CBOR object: map
+// keys are strings sorted by length and then bytewise, with no duplicates;
+// integers and lengths use their shortest encoding; there are no floats,
+// indefinite lengths, tags other than links, or simple values other than
+// true, false and null. Otherwise, it returns an error wrapping
+// ErrNotCanonical.
+func VerifyCanonical(data []byte) error {
+	rest, err := verifyCanonical(data, 0)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 0 {
+		return fmt.Errorf("%w: %d trailing bytes", ErrNotCanonical, len(rest))
+	}
+	return nil
+}
+
+// verifyCanonical checks the object at the start of data and returns the
+// bytes after it.
+func verifyCanonical(data []byte, depth int) ([]byte, error) {
+	if depth > maxCanonicalDepth {
+		return nil, fmt.Errorf("%w: nested more than %d levels deep", ErrNotCanonical, maxCanonicalDepth)
+	}
+
+	maj, n, rest, err := readHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch maj {
+	case majUint, majNegInt:
+		return rest, nil
+	case majBytes, majString:
+		if uint64(len(rest)) < n {
+			return nil, fmt.Errorf("%w: unexpected end of data", ErrNotCanonical)
+		}
+		return rest[n:], nil
+	case majArray:
+		for i := uint64(0); i < n; i++ {
+			if rest, err = verifyCanonical(rest, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return rest, nil
+	case majMap:
+		var prev []byte
+		for i := uint64(0); i < n; i++ {
+			kmaj, _, _, err := readHeader(rest)
+			if err != nil {
+				return nil, err
+			}
+			if kmaj != majString {
+				return nil, fmt.Errorf("%w: map key isn't a string", ErrNotCanonical)
+			}
+			after, err := verifyCanonical(rest, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			key := rest[:len(rest)-len(after)]
+			if prev != nil {
+				switch {
+				case len(key) < len(prev), len(key) == len(prev) && bytes.Compare(key, prev) < 0:
+					return nil, fmt.Errorf("%w: map keys aren't sorted", ErrNotCanonical)
+				case bytes.Equal(key, prev):
+					return nil, fmt.Errorf("%w: duplicate map key", ErrNotCanonical)
+				}
+			}
+			prev = key
+
+			if rest, err = verifyCanonical(after, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return rest, nil
+	case majTag:
+		if n != cidTag {
+			return nil, fmt.Errorf("%w: tag %d", ErrNotCanonical, n)
+		}
+		if maj, _, _, err := readHeader(rest); err != nil {
+			return nil, err
+		} else if maj != majBytes {
+			return nil, fmt.Errorf("%w: link isn't a byte string", ErrNotCanonical)
+		}
+		return verifyCanonical(rest, depth+1)
+	default:
+		switch data[0] & 0x1f {
+		case 20, 21, 22: // false, true, null
+			return rest, nil
+		case 25, 26, 27:
+			return nil, fmt.Errorf("%w: float", ErrNotCanonical)
+		default:
+			return nil, fmt.Errorf("%w: simple value %d", ErrNotCanonical, n)
+		}
+	}
+}
+
+// readHeader reads the header of the object at the start of data, returning
+// its major type, its argument and the bytes after the header. It rejects
+// indefinite lengths and arguments that aren't encoded in the fewest bytes.
+func readHeader(data []byte) (maj byte, n uint64, rest []byte, err error) {
+	if len(data) == 0 {
+		return 0, 0, nil, fmt.Errorf("%w: unexpected end of data", ErrNotCanonical)
+	}
+	maj, info := data[0]>>5, data[0]&0x1f
+	data = data[1:]
+
+	var size int
+	switch {
+	case info < 24:
+		return maj, uint64(info), data, nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	case info == 31:
+		return 0, 0, nil, fmt.Errorf("%w: indefinite length", ErrNotCanonical)
+	default:
+		return 0, 0, nil, fmt.Errorf("%w: reserved additional info %d", ErrNotCanonical, info)
+	}
+	if len(data) < size {
+		return 0, 0, nil, fmt.Errorf("%w: unexpected end of data", ErrNotCanonical)
+	}
+
+	switch size {
+	case 1:
+		n = uint64(data[0])
+	case 2:
+		n = uint64(binary.BigEndian.Uint16(data))
+	case 4:
+		n = uint64(binary.BigEndian.Uint32(data))
+	case 8:
+		n = binary.BigEndian.Uint64(data)
+	}
+
+	// Floats are rejected by the caller, and their size isn't a length.
+	if maj != majOther {
+		min := uint64(24)
+		if size > 1 {
+			min = 1 << (8 * uint(size/2))
+		}
+		if n < min {
+			return 0, 0, nil, fmt.Errorf("%w: %d isn't encoded in the fewest bytes", ErrNotCanonical, n)
+		}
+	}
+	return maj, n, data[size:], nil
+}
@@ -0,0 +1,43 @@
+package cbornode
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestVerifyCanonical(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		data  string
+		valid bool
+	}{
+		{"sorted map", "a261610162616102", true},
+		{"unsorted map", "a262616102616101", false},
+		{"duplicate key", "a2616101616102", false},
+		{"integer key", "a10101", false},
+		{"nested", "8201a0", true},
+		{"null and bools", "83f6f5f4", true},
+		{"undefined", "f7", false},
+		{"float", "fb3ff0000000000000", false},
+		{"indefinite array", "9fff", false},
+		{"non-minimal int", "1801", false},
+		{"non-minimal length", "59000161", false},
+		{"link", "d82a420001", true},
+		{"other tag", "c100", false},
+		{"trailing bytes", "0101", false},
+		{"truncated", "6261", false},
+	} {
+		data, err := hex.DecodeString(test.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = VerifyCanonical(data)
+		if test.valid && err != nil {
+			t.Errorf("%s: expected valid, got %s", test.name, err)
+		}
+		if !test.valid && !errors.Is(err, ErrNotCanonical) {
+			t.Errorf("%s: expected ErrNotCanonical, got %v", test.name, err)
+		}
+	}
+}
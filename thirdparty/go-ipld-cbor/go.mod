@@ -0,0 +1,15 @@
+module github.com/ipfs/go-ipld-cbor
+
+require (
+	github.com/ipfs/go-block-format v0.0.2
+	github.com/ipfs/go-cid v0.0.3
+	github.com/ipfs/go-ipfs-util v0.0.1
+	github.com/ipfs/go-ipld-format v0.0.1
+	github.com/multiformats/go-multihash v0.0.10
+	github.com/polydawn/refmt v0.0.0-20190221155625-df39d6c2d992
+	github.com/smartystreets/goconvey v0.0.0-20190222223459-a17d461953aa // indirect
+	github.com/warpfork/go-wish v0.0.0-20180510122957-5ad1f5abf436 // indirect
+	github.com/whyrusleeping/cbor-gen v0.0.0-20200123233031-1cdf64d27158
+)
+
+go 1.13
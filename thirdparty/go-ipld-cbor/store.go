@@ -0,0 +1,311 @@
+package cbornode
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+
+	block "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+	recbor "github.com/polydawn/refmt/cbor"
+	atlas "github.com/polydawn/refmt/obj/atlas"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+type IpldStore interface {
+	Get(ctx context.Context, c cid.Cid, out interface{}) error
+	Put(ctx context.Context, v interface{}) (cid.Cid, error)
+}
+
+type IpldBlockstore interface {
+	Get(cid.Cid) (block.Block, error)
+	Put(block.Block) error
+}
+
+type BasicIpldStore struct {
+	Blocks IpldBlockstore
+	Atlas  *atlas.Atlas
+
+	// MaxBlockSize is the largest encoded object Put will store, in bytes.
+	// Zero means no limit.
+	MaxBlockSize int
+
+	// Strict makes Put reject objects that don't encode to canonical
+	// DAG-CBOR, as checked by VerifyCanonical, since other implementations
+	// would compute a different CID for them.
+	Strict bool
+
+	validators map[reflect.Type]ValidateFunc
+}
+
+// ValidateFunc checks an object decoded by Get, such as against a schema or a
+// maximum depth. v is the out argument passed to Get.
+type ValidateFunc func(c cid.Cid, v interface{}) error
+
+// RegisterValidator registers f to be run on every object decoded by Get into
+// an out argument of the same type as typ, typically a nil pointer such as
+// (*T)(nil). If f fails, Get returns a ValidationError. Validators must be
+// registered before the store is used.
+func (s *BasicIpldStore) RegisterValidator(typ interface{}, f ValidateFunc) {
+	if s.validators == nil {
+		s.validators = make(map[reflect.Type]ValidateFunc)
+	}
+	s.validators[reflect.TypeOf(typ)] = f
+}
+
+var _ IpldStore = &BasicIpldStore{}
+
+func NewCborStore(bs IpldBlockstore) *BasicIpldStore {
+	return &BasicIpldStore{Blocks: bs}
+}
+
+func (s *BasicIpldStore) Get(ctx context.Context, c cid.Cid, out interface{}) error {
+	blk, err := s.Blocks.Get(c)
+	if err != nil {
+		return err
+	}
+
+	if err := s.decode(blk, out); err != nil {
+		return err
+	}
+
+	if validate, ok := s.validators[reflect.TypeOf(out)]; ok {
+		if err := validate(c, out); err != nil {
+			return ValidationError{Cid: c, err: err}
+		}
+	}
+	return nil
+}
+
+func (s *BasicIpldStore) decode(blk block.Block, out interface{}) error {
+
+	cu, ok := out.(cbg.CBORUnmarshaler)
+	if ok {
+		if err := cu.UnmarshalCBOR(bytes.NewReader(blk.RawData())); err != nil {
+			return NewSerializationError(err)
+		}
+		return nil
+	}
+
+	if s.Atlas == nil {
+		return DecodeInto(blk.RawData(), out)
+	} else {
+		return recbor.UnmarshalAtlased(recbor.DecodeOptions{}, blk.RawData(), out, *s.Atlas)
+	}
+}
+
+// getManyWorkers is the number of blocks GetMany fetches and decodes at once.
+const getManyWorkers = 8
+
+// GetMany fetches and decodes the objects for cids concurrently, and calls f
+// with each of them as it's decoded. Objects are decoded generically; use
+// GetManyInto to decode them into a given type. f is called from a single
+// goroutine, in no particular order. GetMany stops and returns the first
+// error from fetching, decoding or f.
+func (s *BasicIpldStore) GetMany(ctx context.Context, cids []cid.Cid, f func(cid.Cid, interface{}) error) error {
+	return s.GetManyInto(ctx, cids, nil, f)
+}
+
+// GetManyInto is like GetMany, but decodes each object by Get into a fresh
+// value from newOut, such as a pointer to a new T, which is what f receives,
+// so that validators registered for that type run. If newOut is nil, it
+// behaves like GetMany.
+func (s *BasicIpldStore) GetManyInto(ctx context.Context, cids []cid.Cid, newOut func() interface{}, f func(cid.Cid, interface{}) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		c   cid.Cid
+		v   interface{}
+		err error
+	}
+
+	todo := make(chan cid.Cid)
+	go func() {
+		defer close(todo)
+		for _, c := range cids {
+			select {
+			case todo <- c:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	workers := getManyWorkers
+	if len(cids) < workers {
+		workers = len(cids)
+	}
+	results := make(chan result)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for c := range todo {
+				var v interface{}
+				var err error
+				if newOut != nil {
+					v = newOut()
+					err = s.Get(ctx, c, v)
+				} else {
+					err = s.Get(ctx, c, &v)
+				}
+				select {
+				case results <- result{c, v, err}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	done := 0
+	for r := range results {
+		if r.err == nil {
+			r.err = f(r.c, r.v)
+		}
+		if r.err != nil {
+			return r.err
+		}
+		done++
+	}
+	if done == len(cids) {
+		return nil
+	}
+	return ctx.Err()
+}
+
+type cidProvider interface {
+	Cid() cid.Cid
+}
+
+func (s *BasicIpldStore) Put(ctx context.Context, v interface{}) (cid.Cid, error) {
+	c, _, err := s.PutSize(ctx, v)
+	return c, err
+}
+
+// PutSize is like Put, but also returns the size of the encoded object. If
+// the object is larger than MaxBlockSize, it isn't stored and a
+// BlockTooLargeError is returned along with its size.
+func (s *BasicIpldStore) PutSize(ctx context.Context, v interface{}) (cid.Cid, int, error) {
+	mhType := uint64(mh.BLAKE2B_MIN + 31)
+	mhLen := -1
+	codec := uint64(cid.DagCBOR)
+
+	var expCid cid.Cid
+	if c, ok := v.(cidProvider); ok {
+		expCid := c.Cid()
+		pref := expCid.Prefix()
+		mhType = pref.MhType
+		mhLen = pref.MhLength
+		codec = pref.Codec
+	}
+
+	var blk block.Block
+	cm, ok := v.(cbg.CBORMarshaler)
+	if ok {
+		buf := new(bytes.Buffer)
+		if err := cm.MarshalCBOR(buf); err != nil {
+			return cid.Undef, 0, err
+		}
+
+		pref := cid.Prefix{
+			Codec:    codec,
+			MhType:   mhType,
+			MhLength: mhLen,
+			Version:  1,
+		}
+		c, err := pref.Sum(buf.Bytes())
+		if err != nil {
+			return cid.Undef, 0, err
+		}
+
+		blk, err = block.NewBlockWithCid(buf.Bytes(), c)
+		if err != nil {
+			return cid.Undef, 0, err
+		}
+	} else {
+		nd, err := WrapObject(v, mhType, mhLen)
+		if err != nil {
+			return cid.Undef, 0, err
+		}
+		blk = nd
+	}
+
+	size := len(blk.RawData())
+	if s.MaxBlockSize > 0 && size > s.MaxBlockSize {
+		return cid.Undef, size, BlockTooLargeError{Size: size, Max: s.MaxBlockSize}
+	}
+
+	if s.Strict {
+		if err := VerifyCanonical(blk.RawData()); err != nil {
+			return cid.Undef, size, err
+		}
+	}
+
+	if err := s.Blocks.Put(blk); err != nil {
+		return cid.Undef, size, err
+	}
+
+	blkCid := blk.Cid()
+	if expCid != cid.Undef && blkCid != expCid {
+		return cid.Undef, size, fmt.Errorf("your object is not being serialized the way it expects to")
+	}
+
+	return blkCid, size, nil
+}
+
+// BlockTooLargeError is returned by Put when an object's encoding is larger
+// than the store's MaxBlockSize.
+type BlockTooLargeError struct {
+	Size int
+	Max  int
+}
+
+func (e BlockTooLargeError) Error() string {
+	return fmt.Sprintf("encoded object is %d bytes, more than the maximum of %d", e.Size, e.Max)
+}
+
+// ValidationError is returned by Get when a validator registered with
+// RegisterValidator rejects a decoded object.
+type ValidationError struct {
+	Cid cid.Cid
+	err error
+}
+
+func (ve ValidationError) Error() string {
+	return fmt.Sprintf("invalid object %s: %s", ve.Cid, ve.err)
+}
+
+func (ve ValidationError) Unwrap() error {
+	return ve.err
+}
+
+func NewSerializationError(err error) error {
+	return SerializationError{err}
+}
+
+type SerializationError struct {
+	err error
+}
+
+func (se SerializationError) Error() string {
+	return se.err.Error()
+}
+
+func (se SerializationError) Unwrap() error {
+	return se.err
+}
+
+func (se SerializationError) Is(o error) bool {
+	_, ok := o.(*SerializationError)
+	return ok
+}
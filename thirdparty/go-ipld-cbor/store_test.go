@@ -0,0 +1,204 @@
+package cbornode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	block "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+)
+
+type mapBlockstore map[cid.Cid]block.Block
+
+func (bs mapBlockstore) Get(c cid.Cid) (block.Block, error) {
+	blk, ok := bs[c]
+	if !ok {
+		return nil, errors.New("block not found")
+	}
+	return blk, nil
+}
+
+func (bs mapBlockstore) Put(blk block.Block) error {
+	bs[blk.Cid()] = blk
+	return nil
+}
+
+func TestStoreValidator(t *testing.T) {
+	ctx := context.Background()
+	st := NewCborStore(mapBlockstore{})
+
+	good, err := st.Put(ctx, map[string]interface{}{"name": "good"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad, err := st.Put(ctx, map[string]interface{}{"name": "bad"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errBad := errors.New("bad name")
+	st.RegisterValidator((*map[string]interface{})(nil), func(c cid.Cid, v interface{}) error {
+		if (*v.(*map[string]interface{}))["name"] == "bad" {
+			return errBad
+		}
+		return nil
+	})
+
+	var goodOut, badOut map[string]interface{}
+	if err := st.Get(ctx, good, &goodOut); err != nil {
+		t.Fatal(err)
+	}
+
+	err = st.Get(ctx, bad, &badOut)
+	var verr ValidationError
+	if !errors.As(err, &verr) || verr.Cid != bad {
+		t.Fatalf("expected a ValidationError for %s, got %v", bad, err)
+	}
+	if !errors.Is(err, errBad) {
+		t.Fatalf("expected the validator's error to be wrapped, got %v", err)
+	}
+
+	// Validators only apply to the type they were registered for.
+	var iface interface{}
+	if err := st.Get(ctx, bad, &iface); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreGetMany(t *testing.T) {
+	ctx := context.Background()
+	st := NewCborStore(mapBlockstore{})
+
+	want := make(map[cid.Cid]string)
+	var cids []cid.Cid
+	for i := 0; i < 50; i++ {
+		name := fmt.Sprint("obj", i)
+		c, err := st.Put(ctx, map[string]interface{}{"name": name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[c] = name
+		cids = append(cids, c)
+	}
+
+	got := make(map[cid.Cid]string)
+	err := st.GetMany(ctx, cids, func(c cid.Cid, v interface{}) error {
+		got[c] = v.(map[string]interface{})["name"].(string)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d objects, got %d", len(want), len(got))
+	}
+	for c, name := range want {
+		if got[c] != name {
+			t.Fatalf("expected %s to be %q, got %q", c, name, got[c])
+		}
+	}
+
+	errStop := errors.New("stop")
+	calls := 0
+	err = st.GetMany(ctx, cids, func(cid.Cid, interface{}) error {
+		calls++
+		return errStop
+	})
+	if err != errStop || calls != 1 {
+		t.Fatalf("expected GetMany to stop after the first error, got %v after %d calls", err, calls)
+	}
+
+	missing := append(cids[:1:1], cid.NewCidV1(cid.Raw, cids[0].Hash()))
+	if err := st.GetMany(ctx, missing, func(cid.Cid, interface{}) error { return nil }); err == nil {
+		t.Fatal("expected an error for a missing block")
+	}
+}
+
+func TestStoreGetManyValidates(t *testing.T) {
+	ctx := context.Background()
+	st := NewCborStore(mapBlockstore{})
+
+	var cids []cid.Cid
+	for _, name := range []string{"good", "bad"} {
+		c, err := st.Put(ctx, map[string]interface{}{"name": name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cids = append(cids, c)
+	}
+
+	errBad := errors.New("bad name")
+	st.RegisterValidator((*map[string]interface{})(nil), func(c cid.Cid, v interface{}) error {
+		if (*v.(*map[string]interface{}))["name"] == "bad" {
+			return errBad
+		}
+		return nil
+	})
+
+	newOut := func() interface{} { return new(map[string]interface{}) }
+	err := st.GetManyInto(ctx, cids, newOut, func(c cid.Cid, v interface{}) error {
+		if _, ok := v.(*map[string]interface{}); !ok {
+			t.Fatalf("expected a *map[string]interface{}, got %T", v)
+		}
+		return nil
+	})
+	if !errors.Is(err, errBad) {
+		t.Fatalf("expected the validator to reject an object, got %v", err)
+	}
+
+	// The generic decoding has no validator registered.
+	if err := st.GetMany(ctx, cids, func(cid.Cid, interface{}) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreMaxBlockSize(t *testing.T) {
+	ctx := context.Background()
+	bs := mapBlockstore{}
+	st := NewCborStore(bs)
+
+	_, size, err := st.PutSize(ctx, map[string]interface{}{"name": "small"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st.MaxBlockSize = size
+	if _, err := st.Put(ctx, map[string]interface{}{"name": "small"}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, bigSize, err := st.PutSize(ctx, map[string]interface{}{"name": "much larger"})
+	var tooLarge BlockTooLargeError
+	if !errors.As(err, &tooLarge) || tooLarge.Max != size || tooLarge.Size != bigSize || bigSize <= size {
+		t.Fatalf("expected a BlockTooLargeError for %d > %d bytes, got %v", bigSize, size, err)
+	}
+	if len(bs) != 1 {
+		t.Fatalf("expected the large object not to be stored, got %d blocks", len(bs))
+	}
+}
+
+// rawCBOR marshals to its own bytes.
+type rawCBOR []byte
+
+func (r rawCBOR) MarshalCBOR(w io.Writer) error {
+	_, err := w.Write(r)
+	return err
+}
+
+func TestStoreStrict(t *testing.T) {
+	ctx := context.Background()
+	st := NewCborStore(mapBlockstore{})
+	st.Strict = true
+
+	if _, err := st.Put(ctx, map[string]interface{}{"bb": 1, "a": 2, "c": 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	// {"aa": 2, "a": 1}
+	if _, err := st.Put(ctx, rawCBOR{0xa2, 0x62, 0x61, 0x61, 0x02, 0x61, 0x61, 0x01}); !errors.Is(err, ErrNotCanonical) {
+		t.Fatalf("expected ErrNotCanonical, got %v", err)
+	}
+}